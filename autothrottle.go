@@ -0,0 +1,249 @@
+package goribot
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AutoThrottleOptions is the options of AutoThrottle,the zero fields use the default values
+type AutoThrottleOptions struct {
+	// TargetConcurrency is the average number of parallel requests sent to each host,1 by default
+	TargetConcurrency float64
+	// StartDelay is the initial delay between requests to a host,1 second by default
+	StartDelay time.Duration
+	// MinDelay is the min delay between requests to a host
+	MinDelay time.Duration
+	// MaxDelay is the max delay between requests to a host,1 minute by default
+	MaxDelay time.Duration
+	// MaxConcurrency is the max number of parallel requests sent to a host,8 by default
+	MaxConcurrency int
+	// BackoffFactor multiplies the delay on backoff,2 by default
+	BackoffFactor float64
+	// BackoffStatus are the status codes causing backoff,429 and 503 by default.Timeouts always cause backoff.
+	BackoffStatus []int
+}
+
+// HostThrottle is the current throttle settings of a host
+type HostThrottle struct {
+	Delay       time.Duration
+	Concurrency int
+	Running     int
+	// Latency is the moving average of response latency
+	Latency  time.Duration
+	Requests int64
+	Backoffs int64
+}
+
+type hostThrottle struct {
+	HostThrottle
+	// wake is closed and replaced when a slot may be free,so the waiters could select it with ctx.Done()
+	wake      chan struct{}
+	next      time.Time
+	successes int
+}
+
+// notify wakes up the requests waiting for a slot of the host
+func (s *hostThrottle) notify() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// AutoThrottler adjusts the delay and concurrency of each host,see AutoThrottle
+type AutoThrottler struct {
+	opts  AutoThrottleOptions
+	lock  sync.Mutex
+	hosts map[string]*hostThrottle
+}
+
+// AutoThrottle is an extension adjusts the delay and concurrency of each host by the response latency,
+// see NewAutoThrottle
+func AutoThrottle(opts AutoThrottleOptions) func(s *Spider) {
+	ext, _ := NewAutoThrottle(opts)
+	return ext
+}
+
+// NewAutoThrottle creates an extension adjusts the delay and concurrency of each host by the response latency,
+// and an AutoThrottler exposes the current settings of hosts.
+// The delay goes toward latency/TargetConcurrency, and the concurrency grows by one after as many successful
+// responses as itself. On the BackoffStatus or timeout, the delay is multiplied by BackoffFactor and
+// the concurrency is halved, then they recover gradually.
+func NewAutoThrottle(opts AutoThrottleOptions) (func(s *Spider), *AutoThrottler) {
+	if opts.TargetConcurrency <= 0 {
+		opts.TargetConcurrency = 1
+	}
+	if opts.StartDelay <= 0 {
+		opts.StartDelay = time.Second
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = time.Minute
+	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = 8
+	}
+	if opts.BackoffFactor <= 1 {
+		opts.BackoffFactor = 2
+	}
+	if opts.BackoffStatus == nil {
+		opts.BackoffStatus = []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+	}
+	a := &AutoThrottler{opts: opts, hosts: map[string]*hostThrottle{}}
+	return func(s *Spider) {
+		s.Downloader.AddMiddleware(func(req *Request, next func(req *Request) (resp *Response, err error)) (resp *Response, err error) {
+			h := a.host(req.URL.Host)
+			if err := a.acquire(req.Request.Context(), h); err != nil {
+				return nil, err
+			}
+			start := time.Now()
+			resp, err = next(req)
+			a.release(h, time.Since(start), resp, err)
+			return resp, err
+		})
+	}, a
+}
+
+func (s *AutoThrottler) host(host string) *hostThrottle {
+	host = strings.ToLower(host)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &hostThrottle{wake: make(chan struct{})}
+		h.Delay = s.clampDelay(s.opts.StartDelay)
+		h.Concurrency = int(math.Ceil(s.opts.TargetConcurrency))
+		if h.Concurrency > s.opts.MaxConcurrency {
+			h.Concurrency = s.opts.MaxConcurrency
+		}
+		s.hosts[host] = h
+	}
+	return h
+}
+
+func (s *AutoThrottler) clampDelay(d time.Duration) time.Duration {
+	if d < s.opts.MinDelay {
+		return s.opts.MinDelay
+	}
+	if d > s.opts.MaxDelay {
+		return s.opts.MaxDelay
+	}
+	return d
+}
+
+// acquire waits for a free slot of the host and the delay from the last request,
+// returns the error of ctx if it's done while waiting
+func (s *AutoThrottler) acquire(ctx context.Context, h *hostThrottle) error {
+	s.lock.Lock()
+	for h.Running >= h.Concurrency {
+		wake := h.wake
+		s.lock.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.lock.Lock()
+	}
+	h.Running += 1
+	h.Requests += 1
+	now := time.Now()
+	if h.next.Before(now) {
+		h.next = now
+	}
+	wait := h.next.Sub(now)
+	h.next = h.next.Add(h.Delay)
+	s.lock.Unlock()
+
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			s.lock.Lock()
+			h.Running -= 1
+			h.notify()
+			s.lock.Unlock()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *AutoThrottler) shouldBackoff(resp *Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	}
+	for _, c := range s.opts.BackoffStatus {
+		if resp.StatusCode == c {
+			return true
+		}
+	}
+	return false
+}
+
+// release updates the settings of host by the result of request
+func (s *AutoThrottler) release(h *hostThrottle, latency time.Duration, resp *Response, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	h.Running -= 1
+	defer h.notify()
+
+	if s.shouldBackoff(resp, err) {
+		h.Backoffs += 1
+		h.successes = 0
+		d := time.Duration(float64(h.Delay) * s.opts.BackoffFactor)
+		if d < s.opts.StartDelay {
+			d = s.opts.StartDelay
+		}
+		if err == nil {
+			if ra, e := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After"))); e == nil && time.Duration(ra)*time.Second > d {
+				d = time.Duration(ra) * time.Second
+			}
+		}
+		h.Delay = s.clampDelay(d)
+		if h.Concurrency > 1 {
+			h.Concurrency /= 2
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if h.Latency == 0 {
+		h.Latency = latency
+	} else {
+		h.Latency = (h.Latency*3 + latency) / 4
+	}
+	// the delay isn't decreased by error responses,which may be returned faster
+	target := time.Duration(float64(latency) / s.opts.TargetConcurrency)
+	d := (h.Delay + target) / 2
+	if resp.StatusCode >= 400 && d < h.Delay {
+		d = h.Delay
+	}
+	h.Delay = s.clampDelay(d)
+
+	h.successes += 1
+	if h.successes >= h.Concurrency && h.Concurrency < s.opts.MaxConcurrency {
+		h.Concurrency += 1
+		h.successes = 0
+	}
+}
+
+// Stats returns the current settings of all hosts
+func (s *AutoThrottler) Stats() map[string]HostThrottle {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := map[string]HostThrottle{}
+	for k, h := range s.hosts {
+		res[k] = h.HostThrottle
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package goribot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoThrottle(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+	busy := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	ext, throttler := NewAutoThrottle(AutoThrottleOptions{StartDelay: 10 * time.Millisecond, MaxDelay: time.Second, MaxConcurrency: 4})
+	s := NewSpider(ext)
+	for i := 0; i < 8; i++ {
+		s.AddTask(Get(ts.URL + "/?" + string(rune('a'+i))))
+	}
+	for i := 0; i < 3; i++ {
+		s.AddTask(Get(busy + "/busy?" + string(rune('a'+i))))
+	}
+	s.Run()
+
+	stats := throttler.Stats()
+	ok := stats[strings.TrimPrefix(ts.URL, "http://")]
+	if ok.Requests != 8 || ok.Backoffs != 0 || ok.Delay < 30*time.Millisecond || ok.Latency < 50*time.Millisecond || ok.Concurrency < 2 {
+		t.Error("wrong throttle of normal host", ok)
+	}
+	b := stats[strings.TrimPrefix(busy, "http://")]
+	if b.Backoffs != 3 || b.Delay != 80*time.Millisecond || b.Concurrency != 1 || b.Running != 0 {
+		t.Error("wrong throttle of busy host", b)
+	}
+
+	// a cancelled request doesn't wait for a slot forever
+	_, throttler = NewAutoThrottle(AutoThrottleOptions{StartDelay: time.Millisecond})
+	h := throttler.host("example.com")
+	if err := throttler.acquire(context.Background(), h); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := throttler.acquire(ctx, h); err != context.DeadlineExceeded {
+		t.Error("cancelled request got a slot", err)
+	}
+	if h.Running != 1 {
+		t.Error("wrong running requests", h.Running)
+	}
+}
@@ -0,0 +1,294 @@
+package goribot
+
+import (
+	"errors"
+	"github.com/go-redis/redis"
+	"sync"
+	"time"
+)
+
+// QueueBackend stores the queues and workers of distributed mode,
+// see RedisBackend, MemoryBackend and RemoteBackend
+type QueueBackend interface {
+	// Push pushes data to the tail of queue
+	Push(queue string, data []byte) error
+	// Pop pops data from the head of queue,returns nil if the queue is empty
+	Pop(queue string) ([]byte, error)
+	// Move pops data from the head of src and pushes it to the tail of dst atomically,returns nil if src is empty
+	Move(src, dst string) ([]byte, error)
+	// Remove removes one element equal to data from queue
+	Remove(queue string, data []byte) error
+	// Len returns the length of queue
+	Len(queue string) (int64, error)
+
+	// Heartbeat records the member of set is alive at t
+	Heartbeat(set, member string, t time.Time) error
+	// Members returns the members of set and their last heartbeat time
+	Members(set string) (map[string]time.Time, error)
+	// RemoveMember removes the member from set
+	RemoveMember(set, member string) error
+
+	// SetValue sets the value of key,nil deletes the key
+	SetValue(key string, data []byte) error
+	// GetValue returns the value of key,nil if the key doesn't exist
+	GetValue(key string) ([]byte, error)
+}
+
+// DedupBackend stores the sets of deduplicate
+type DedupBackend interface {
+	// Add adds key to set,returns false if it's already in the set
+	Add(set string, key []byte) (bool, error)
+	// Clear removes all the keys of set
+	Clear(set string) error
+}
+
+// Backend is both QueueBackend and DedupBackend
+type Backend interface {
+	QueueBackend
+	DedupBackend
+}
+
+// RedisBackend is the Backend based on redis
+type RedisBackend struct {
+	Client *redis.Client
+}
+
+// NewRedisBackend creates a RedisBackend
+func NewRedisBackend(c *redis.Client) *RedisBackend {
+	return &RedisBackend{Client: c}
+}
+
+// the head of queue is the right side of redis list,so Move is RPOPLPUSH
+
+func (s *RedisBackend) Push(queue string, data []byte) error {
+	return s.Client.LPush(queue, data).Err()
+}
+
+func (s *RedisBackend) Pop(queue string) ([]byte, error) {
+	return redisBytes(s.Client.RPop(queue))
+}
+
+func (s *RedisBackend) Move(src, dst string) ([]byte, error) {
+	return redisBytes(s.Client.RPopLPush(src, dst))
+}
+
+func redisBytes(c *redis.StringCmd) ([]byte, error) {
+	res, err := c.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return res, err
+}
+
+func (s *RedisBackend) Remove(queue string, data []byte) error {
+	return s.Client.LRem(queue, 1, data).Err()
+}
+
+func (s *RedisBackend) Len(queue string) (int64, error) {
+	return s.Client.LLen(queue).Result()
+}
+
+func (s *RedisBackend) Heartbeat(set, member string, t time.Time) error {
+	return s.Client.ZAdd(set, redis.Z{Score: float64(t.UnixNano() / 1e6), Member: member}).Err()
+}
+
+func (s *RedisBackend) Members(set string) (map[string]time.Time, error) {
+	res, err := s.Client.ZRangeWithScores(set, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := map[string]time.Time{}
+	for _, z := range res {
+		members[z.Member.(string)] = time.Unix(0, int64(z.Score)*1e6)
+	}
+	return members, nil
+}
+
+func (s *RedisBackend) RemoveMember(set, member string) error {
+	return s.Client.ZRem(set, member).Err()
+}
+
+func (s *RedisBackend) SetValue(key string, data []byte) error {
+	if data == nil {
+		return s.Client.Del(key).Err()
+	}
+	return s.Client.Set(key, data, 0).Err()
+}
+
+func (s *RedisBackend) GetValue(key string) ([]byte, error) {
+	return redisBytes(s.Client.Get(key))
+}
+
+func (s *RedisBackend) Add(set string, key []byte) (bool, error) {
+	res, err := s.Client.SAdd(set, key).Result()
+	return res == 1, err
+}
+
+func (s *RedisBackend) Clear(set string) error {
+	return s.Client.Del(set).Err()
+}
+
+// MemoryBackend is the Backend in memory,which is used for testing,
+// or serving the workers in other processes by ServeBackend
+type MemoryBackend struct {
+	lock    sync.Mutex
+	queues  map[string][][]byte
+	members map[string]map[string]time.Time
+	sets    map[string]map[string]struct{}
+	values  map[string][]byte
+}
+
+// NewMemoryBackend creates a MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		queues:  map[string][][]byte{},
+		members: map[string]map[string]time.Time{},
+		sets:    map[string]map[string]struct{}{},
+		values:  map[string][]byte{},
+	}
+}
+
+func (s *MemoryBackend) Push(queue string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queues[queue] = append(s.queues[queue], append([]byte{}, data...))
+	return nil
+}
+
+func (s *MemoryBackend) pop(queue string) []byte {
+	q := s.queues[queue]
+	if len(q) == 0 {
+		return nil
+	}
+	s.queues[queue] = q[1:]
+	return q[0]
+}
+
+func (s *MemoryBackend) Pop(queue string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pop(queue), nil
+}
+
+func (s *MemoryBackend) Move(src, dst string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := s.pop(src)
+	if res != nil {
+		s.queues[dst] = append(s.queues[dst], res)
+	}
+	return res, nil
+}
+
+func (s *MemoryBackend) Remove(queue string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	q := s.queues[queue]
+	for k, i := range q {
+		if string(i) == string(data) {
+			s.queues[queue] = append(q[:k:k], q[k+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryBackend) Len(queue string) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return int64(len(s.queues[queue])), nil
+}
+
+func (s *MemoryBackend) Heartbeat(set, member string, t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.members[set] == nil {
+		s.members[set] = map[string]time.Time{}
+	}
+	s.members[set][member] = t
+	return nil
+}
+
+func (s *MemoryBackend) Members(set string) (map[string]time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := map[string]time.Time{}
+	for k, v := range s.members[set] {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (s *MemoryBackend) RemoveMember(set, member string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.members[set], member)
+	return nil
+}
+
+func (s *MemoryBackend) SetValue(key string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if data == nil {
+		delete(s.values, key)
+	} else {
+		s.values[key] = append([]byte{}, data...)
+	}
+	return nil
+}
+
+func (s *MemoryBackend) GetValue(key string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.values[key], nil
+}
+
+func (s *MemoryBackend) Add(set string, key []byte) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sets[set] == nil {
+		s.sets[set] = map[string]struct{}{}
+	}
+	if _, ok := s.sets[set][string(key)]; ok {
+		return false, nil
+	}
+	s.sets[set][string(key)] = struct{}{}
+	return true, nil
+}
+
+func (s *MemoryBackend) Clear(set string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sets, set)
+	return nil
+}
+
+// reapTasks re-queues the processing tasks of workers without heartbeat for timeout and unregisters them,
+// returns the number of tasks re-queued
+func reapTasks(b QueueBackend, sName string, timeout time.Duration) (int, error) {
+	workers, err := b.Members(sName + WorkersSuffix)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	deadline := time.Now().Add(-timeout)
+	for w, t := range workers {
+		if t.After(deadline) {
+			continue
+		}
+		for {
+			res, err := b.Move(sName+ProcessingSuffix+"_"+w, sName+TasksSuffix)
+			if err != nil {
+				return n, err
+			}
+			if res == nil {
+				break
+			}
+			n += 1
+		}
+		if err = b.RemoveMember(sName+WorkersSuffix, w); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,148 @@
+package goribot
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testBackend(t *testing.T, b Backend) {
+	for _, i := range []string{"a", "b", "c"} {
+		if err := b.Push("q", []byte(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res, err := b.Pop("q"); string(res) != "a" || err != nil {
+		t.Error("wrong Pop", string(res), err)
+	}
+	if res, err := b.Move("q", "p"); string(res) != "b" || err != nil {
+		t.Error("wrong Move", string(res), err)
+	}
+	if n, _ := b.Len("p"); n != 1 {
+		t.Error("wrong Len", n)
+	}
+	_ = b.Remove("p", []byte("b"))
+	if n, _ := b.Len("p"); n != 0 {
+		t.Error("wrong Remove", n)
+	}
+	_, _ = b.Pop("q")
+	if res, err := b.Pop("q"); res != nil || err != nil {
+		t.Error("empty queue should return nil", res, err)
+	}
+
+	now := time.Now()
+	_ = b.Heartbeat("w", "w1", now)
+	_ = b.Heartbeat("w", "w2", now)
+	_ = b.RemoveMember("w", "w2")
+	// redis keeps the time in ms
+	if m, err := b.Members("w"); len(m) != 1 || now.Sub(m["w1"]) < 0 || now.Sub(m["w1"]) >= time.Millisecond || err != nil {
+		t.Error("wrong Members", m, err)
+	}
+
+	if ok, _ := b.Add("s", []byte("k")); !ok {
+		t.Error("key should be added")
+	}
+	if ok, _ := b.Add("s", []byte("k")); ok {
+		t.Error("key shouldn't be added twice")
+	}
+	_ = b.Clear("s")
+	if ok, _ := b.Add("s", []byte("k")); !ok {
+		t.Error("set isn't cleared")
+	}
+
+	_ = b.SetValue("v", []byte("value"))
+	if res, err := b.GetValue("v"); string(res) != "value" || err != nil {
+		t.Error("wrong GetValue", string(res), err)
+	}
+	_ = b.SetValue("v", nil)
+	if res, err := b.GetValue("v"); res != nil || err != nil {
+		t.Error("value isn't deleted", res, err)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+func TestRemoteBackend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	mb := NewMemoryBackend()
+	go func() { _ = ServeBackend(l, mb) }()
+	b, err := DialBackend(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	testBackend(t, b)
+	_ = b.SetValue("empty", []byte{})
+	if res, _ := mb.GetValue("empty"); res == nil {
+		t.Error("empty value is deleted")
+	}
+	_ = b.SetValue("empty", nil)
+	if res, _ := mb.GetValue("empty"); res != nil {
+		t.Error("value isn't deleted", res)
+	}
+
+	// distributed crawl without redis
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	sName := "RemoteTest"
+	got := make(chan interface{}, 1)
+	m := NewBackendManager(b, sName)
+	m.OnItem(func(i interface{}) interface{} {
+		got <- i
+		return i
+	})
+	m.SendReq(Get(ts.URL + "/seed"))
+	w, err := DialBackend(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	s := NewSpider(
+		Distributed(w, sName, true, func(ctx *Context) {
+			ctx.AddItem(ctx.Req.URL.Path)
+		}),
+		DistributedReliable(time.Second),
+	)
+	done, mDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+	go func() {
+		m.Run()
+		close(mDone)
+	}()
+	select {
+	case i := <-got:
+		if i != "/seed" {
+			t.Error("wrong item", i)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("lost item")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n, _ := b.Len(sName + ProcessingSuffix + "_" + s.Scheduler.(*DistributedScheduler).WorkerID()); n != 0 {
+		t.Error("task isn't acked", n)
+	}
+
+	// stop the worker and the manager before the backend is closed
+	if err := m.Broadcast(CommandStop); err != nil {
+		t.Fatal(err)
+	}
+	m.Stop()
+	for _, c := range []chan struct{}{done, mDone} {
+		select {
+		case <-c:
+		case <-time.After(15 * time.Second):
+			t.Fatal("worker or manager isn't stopped")
+		}
+	}
+}
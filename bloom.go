@@ -0,0 +1,406 @@
+package goribot
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+var ErrBadBloomFilterData = errors.New("bad bloom filter data")
+
+// ErrBadBloomFilterParams is the panic of creating a bloom filter with capacity 0 or a false positive rate out of (0,1)
+var ErrBadBloomFilterParams = errors.New("bad bloom filter params,capacity must be positive and fpRate must be in (0,1)")
+
+// maxBloomBits is the max bits of a bloom filter or a layer of scalable bloom filter,
+// which is the limit of redis bit offset
+const maxBloomBits uint64 = 1 << 32
+
+// maxBloomLayers is the max layers of a scalable bloom filter,the last layer keeps taking elements after that
+const maxBloomLayers = 64
+
+const bloomFilterMagic = "GRBF"
+const bloomFilterVersion uint32 = 1
+
+// bloomParams returns the bits number and hash functions number of a bloom filter
+// holds n elements with false positive rate p
+func bloomParams(n uint64, p float64) (m, k uint64) {
+	bits := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	if bits >= float64(maxBloomBits) {
+		m = maxBloomBits
+	} else {
+		m = uint64(bits)
+	}
+	k = uint64(math.Ceil(-math.Log(p) / math.Ln2))
+	if m == 0 {
+		m = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return m, k
+}
+
+// bloomLayerParams returns the capacity and false positive rate of the i-th layer of a scalable bloom filter.
+// Every layer doubles the capacity and halves the false positive rate,so the total rate stays under p.
+// The capacity is limited so that a layer takes at most maxBloomBits.
+func bloomLayerParams(n uint64, p float64, i int) (uint64, float64) {
+	p = p / math.Pow(2, float64(i+1))
+	c := float64(n) * math.Pow(2, float64(i))
+	if max := math.Floor(float64(maxBloomBits) * math.Ln2 * math.Ln2 / -math.Log(p)); c > max {
+		c = max
+	}
+	if c < 1 {
+		c = 1
+	}
+	return uint64(c), p
+}
+
+// validBloomParams returns whether a bloom filter could be built with the params
+func validBloomParams(capacity uint64, fpRate float64) bool {
+	return capacity > 0 && fpRate > 0 && fpRate < 1
+}
+
+// BloomFilter is a bloom filter with fixed capacity
+type BloomFilter struct {
+	m, k, count, capacity uint64
+	bits                  []uint64
+}
+
+// NewBloomFilter creates a bloom filter holds capacity elements with false positive rate fpRate.
+// It panics with ErrBadBloomFilterParams if capacity is 0 or fpRate isn't in (0,1).
+// The filter takes at most 2^32 bits,the false positive rate rises if the capacity needs more.
+func NewBloomFilter(capacity uint64, fpRate float64) *BloomFilter {
+	if !validBloomParams(capacity, fpRate) {
+		panic(ErrBadBloomFilterParams)
+	}
+	m, k := bloomParams(capacity, fpRate)
+	return &BloomFilter{m: m, k: k, capacity: capacity, bits: make([]uint64, (m+63)/64)}
+}
+
+func (s *BloomFilter) location(h [md5.Size]byte, i uint64) uint64 {
+	h1, h2 := binary.BigEndian.Uint64(h[0:8]), binary.BigEndian.Uint64(h[8:16])
+	return (h1 + i*h2) % s.m
+}
+
+func (s *BloomFilter) test(h [md5.Size]byte) bool {
+	for i := uint64(0); i < s.k; i++ {
+		l := s.location(h, i)
+		if s.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *BloomFilter) add(h [md5.Size]byte) {
+	for i := uint64(0); i < s.k; i++ {
+		l := s.location(h, i)
+		s.bits[l/64] |= 1 << (l % 64)
+	}
+	s.count += 1
+}
+
+// Test returns whether the data may be in the filter
+func (s *BloomFilter) Test(data []byte) bool {
+	return s.test(md5.Sum(data))
+}
+
+// Add adds the data to the filter
+func (s *BloomFilter) Add(data []byte) {
+	s.add(md5.Sum(data))
+}
+
+// Count returns the number of elements added
+func (s *BloomFilter) Count() uint64 {
+	return s.count
+}
+
+// ScalableBloomFilter is a bloom filter grows when the elements exceed the capacity,
+// keeping the false positive rate under the given value.It's safe for concurrent use.
+type ScalableBloomFilter struct {
+	lock     sync.Mutex
+	capacity uint64
+	fpRate   float64
+	filters  []*BloomFilter
+}
+
+// NewScalableBloomFilter creates a scalable bloom filter,capacity is the initial capacity.
+// It panics with ErrBadBloomFilterParams if capacity is 0 or fpRate isn't in (0,1).
+func NewScalableBloomFilter(capacity uint64, fpRate float64) *ScalableBloomFilter {
+	if !validBloomParams(capacity, fpRate) {
+		panic(ErrBadBloomFilterParams)
+	}
+	s := &ScalableBloomFilter{capacity: capacity, fpRate: fpRate}
+	s.grow()
+	return s
+}
+
+func (s *ScalableBloomFilter) grow() {
+	n, p := bloomLayerParams(s.capacity, s.fpRate, len(s.filters))
+	s.filters = append(s.filters, NewBloomFilter(n, p))
+}
+
+func (s *ScalableBloomFilter) test(h [md5.Size]byte) bool {
+	for _, f := range s.filters {
+		if f.test(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Test returns whether the data may be in the filter
+func (s *ScalableBloomFilter) Test(data []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.test(md5.Sum(data))
+}
+
+// TestAndAdd adds the data to the filter and returns whether it may be in the filter before
+func (s *ScalableBloomFilter) TestAndAdd(data []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	h := md5.Sum(data)
+	if s.test(h) {
+		return true
+	}
+	last := s.filters[len(s.filters)-1]
+	if last.count >= last.capacity && len(s.filters) < maxBloomLayers {
+		s.grow()
+		last = s.filters[len(s.filters)-1]
+	}
+	last.add(h)
+	return false
+}
+
+// Count returns the number of elements added
+func (s *ScalableBloomFilter) Count() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var c uint64
+	for _, f := range s.filters {
+		c += f.count
+	}
+	return c
+}
+
+// WriteTo writes the filter to w
+func (s *ScalableBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cw := &countWriter{w: w}
+	data := []interface{}{[]byte(bloomFilterMagic), bloomFilterVersion, s.capacity, s.fpRate, uint32(len(s.filters))}
+	for _, f := range s.filters {
+		data = append(data, f.m, f.k, f.count, f.capacity, f.bits)
+	}
+	for _, d := range data {
+		if err := binary.Write(cw, binary.BigEndian, d); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// ReadFrom replaces the filter with the data read from r,
+// the filter is unchanged if the data is bad or can't be read completely
+func (s *ScalableBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cr := &countReader{r: r}
+	magic := make([]byte, len(bloomFilterMagic))
+	var version, layers uint32
+	var capacity uint64
+	var fpRate float64
+	if _, err := io.ReadFull(cr, magic); err != nil {
+		return cr.n, err
+	}
+	if string(magic) != bloomFilterMagic {
+		return cr.n, ErrBadBloomFilterData
+	}
+	for _, d := range []interface{}{&version, &capacity, &fpRate, &layers} {
+		if err := binary.Read(cr, binary.BigEndian, d); err != nil {
+			return cr.n, err
+		}
+	}
+	if version != bloomFilterVersion || !validBloomParams(capacity, fpRate) || layers > maxBloomLayers {
+		return cr.n, ErrBadBloomFilterData
+	}
+	var filters []*BloomFilter
+	for i := uint32(0); i < layers; i++ {
+		f := &BloomFilter{}
+		for _, d := range []interface{}{&f.m, &f.k, &f.count, &f.capacity} {
+			if err := binary.Read(cr, binary.BigEndian, d); err != nil {
+				return cr.n, err
+			}
+		}
+		// the layer must be the one grown from the capacity and rate of filter
+		n, p := bloomLayerParams(capacity, fpRate, int(i))
+		if m, k := bloomParams(n, p); f.m != m || f.k != k || f.capacity != n {
+			return cr.n, ErrBadBloomFilterData
+		}
+		// read the bits by chunks,so a truncated data doesn't allocate the whole layer
+		l := (f.m + 63) / 64
+		chunk := make([]uint64, 8192)
+		for uint64(len(f.bits)) < l {
+			c := chunk
+			if rest := l - uint64(len(f.bits)); rest < uint64(len(c)) {
+				c = c[:rest]
+			}
+			if err := binary.Read(cr, binary.BigEndian, c); err != nil {
+				return cr.n, err
+			}
+			f.bits = append(f.bits, c...)
+		}
+		filters = append(filters, f)
+	}
+	s.capacity, s.fpRate, s.filters = capacity, fpRate, filters
+	if len(s.filters) == 0 {
+		s.grow()
+	}
+	return cr.n, nil
+}
+
+// SaveFile saves the filter to a file
+func (s *ScalableBloomFilter) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if _, err = s.WriteTo(w); err == nil {
+		err = w.Flush()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// LoadScalableBloomFilter loads a filter saved by ScalableBloomFilter.SaveFile
+func LoadScalableBloomFilter(path string) (*ScalableBloomFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &ScalableBloomFilter{}
+	if _, err = s.ReadFrom(bufio.NewReader(f)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (s *countWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	return n, err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (s *countReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	return n, err
+}
+
+// BloomReqDeduplicate is an extension can deduplicate new task with a scalable bloom filter.
+// It takes much less memory than ReqDeduplicate, at the cost of dropping a few new tasks by false positive.
+func BloomReqDeduplicate(f *ScalableBloomFilter, fp ...*Fingerprinter) func(s *Spider) {
+	fingerprinter := getFingerprinter(fp)
+	return func(s *Spider) {
+		deduplicate(s, fingerprinter, func(has [md5.Size]byte) bool {
+			return f.TestAndAdd(has[:])
+		})
+	}
+}
+
+// redisBloomScript checks and sets the bits of a scalable bloom filter stored in redis atomically.
+// The layers are kept in the same way as ScalableBloomFilter.
+var redisBloomScript = redis.NewScript(`
+local key = KEYS[1]
+local h1, h2 = tonumber(ARGV[1]), tonumber(ARGV[2])
+local capacity, rate = tonumber(ARGV[3]), tonumber(ARGV[4])
+local maxBits, maxLayers = tonumber(ARGV[5]), tonumber(ARGV[6])
+local layers = tonumber(redis.call('HGET', key, 'layers')) or 0
+local function params(i)
+	local p = rate / 2 ^ (i + 1)
+	local n = math.min(capacity * 2 ^ i, math.floor(maxBits * math.log(2) ^ 2 / -math.log(p)))
+	n = math.max(n, 1)
+	local m = math.min(math.ceil(-n * math.log(p) / (math.log(2) ^ 2)), maxBits)
+	local k = math.ceil(-math.log(p) / math.log(2))
+	return n, m, k
+end
+for i = 0, layers - 1 do
+	local _, m, k = params(i)
+	local hit = true
+	for j = 0, k - 1 do
+		if redis.call('GETBIT', key .. '_' .. i, (h1 + j * h2) % m) == 0 then
+			hit = false
+			break
+		end
+	end
+	if hit then
+		return 1
+	end
+end
+local last = layers - 1
+if layers == 0 or (layers < maxLayers and (tonumber(redis.call('HGET', key, 'count_' .. last)) or 0) >= params(last)) then
+	last = layers
+	redis.call('HSET', key, 'layers', layers + 1)
+end
+local _, m, k = params(last)
+for j = 0, k - 1 do
+	redis.call('SETBIT', key .. '_' .. last, (h1 + j * h2) % m, 1)
+end
+redis.call('HINCRBY', key, 'count_' .. last, 1)
+return 0
+`)
+
+// resetRedisBloom deletes the bloom filter stored in redis
+func resetRedisBloom(r *redis.Client, key string) {
+	layers, _ := r.HGet(key, "layers").Int64()
+	keys := []string{key}
+	for i := int64(0); i < layers; i++ {
+		keys = append(keys, key+"_"+fmt.Sprint(i))
+	}
+	r.Del(keys...)
+}
+
+// RedisBloomReqDeduplicate is an extension can deduplicate new task with a scalable bloom filter stored in redis,
+// which support distributed like RedisReqDeduplicate but don't keep an ever-growing set.
+// It panics with ErrBadBloomFilterParams if capacity is 0 or fpRate isn't in (0,1).
+func RedisBloomReqDeduplicate(r *redis.Client, sName string, capacity uint64, fpRate float64, fp ...*Fingerprinter) func(s *Spider) {
+	if !validBloomParams(capacity, fpRate) {
+		panic(ErrBadBloomFilterParams)
+	}
+	f := getFingerprinter(fp)
+	return func(s *Spider) {
+		deduplicate(s, f, func(has [md5.Size]byte) bool {
+			res, err := redisBloomScript.Run(
+				r, []string{sName + BloomSuffix},
+				binary.BigEndian.Uint32(has[0:4]), binary.BigEndian.Uint32(has[4:8]), capacity, fpRate, maxBloomBits, maxBloomLayers,
+			).Int64()
+			if err != nil {
+				Log.Error(err)
+				return false
+			}
+			return res == 1
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package goribot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScalableBloomFilter(t *testing.T) {
+	f := NewScalableBloomFilter(1000, 0.01)
+	for i := 0; i < 5000; i++ {
+		f.TestAndAdd([]byte(fmt.Sprint("in", i)))
+	}
+	if len(f.filters) < 2 {
+		t.Error("filter didn't grow", len(f.filters))
+	}
+	for i := 0; i < 5000; i++ {
+		if !f.Test([]byte(fmt.Sprint("in", i))) {
+			t.Fatal("false negative", i)
+		}
+	}
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test([]byte(fmt.Sprint("out", i))) {
+			fp += 1
+		}
+	}
+	if fp > 100 {
+		t.Error("false positive rate too high", fp)
+	}
+
+	path := filepath.Join(os.TempDir(), "goribot_bloom_test")
+	defer os.Remove(path)
+	if err := f.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadScalableBloomFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Count() != f.Count() || !l.Test([]byte("in42")) || l.TestAndAdd([]byte("new")) {
+		t.Error("wrong loaded filter")
+	}
+}
+
+func TestBadBloomFilter(t *testing.T) {
+	for _, p := range []struct {
+		capacity uint64
+		fpRate   float64
+	}{{0, 0.01}, {100, 0}, {100, 1}, {100, -1}} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrBadBloomFilterParams {
+					t.Error("bad params accepted", p, r)
+				}
+			}()
+			NewScalableBloomFilter(p.capacity, p.fpRate)
+		}()
+	}
+
+	// the layers of huge capacity are limited
+	if n, p := bloomLayerParams(1<<62, 0.01, 3); n == 0 || p != 0.01/16 {
+		t.Error("wrong layer params", n, p)
+	} else if m, _ := bloomParams(n, p); m > maxBloomBits {
+		t.Error("layer too large", m)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := NewScalableBloomFilter(100, 0.01).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+	corrupt := func(offset int, v interface{}) []byte {
+		w := &bytes.Buffer{}
+		_ = binary.Write(w, binary.BigEndian, v)
+		data := append([]byte{}, good...)
+		copy(data[offset:], w.Bytes())
+		return data
+	}
+	header := len(bloomFilterMagic) + 4 // magic and version
+	for name, data := range map[string][]byte{
+		"zero capacity": corrupt(header, uint64(0)),
+		"bad rate":      corrupt(header+8, float64(2)),
+		"zero bits":     corrupt(header+20, uint64(0)),
+		"huge bits":     corrupt(header+20, uint64(1<<62)),
+		"truncated":     good[:len(good)-8],
+	} {
+		f := NewScalableBloomFilter(10, 0.1)
+		f.TestAndAdd([]byte("a"))
+		if _, err := f.ReadFrom(bytes.NewReader(data)); err == nil {
+			t.Error("bad data accepted", name)
+		} else if name != "truncated" && !errors.Is(err, ErrBadBloomFilterData) {
+			t.Error("wrong error", name, err)
+		}
+		if !f.Test([]byte("a")) || f.capacity != 10 {
+			t.Error("filter changed by bad data", name)
+		}
+	}
+}
+
+func TestBloomReqDeduplicate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "Hello goribot")
+	}))
+	defer ts.Close()
+
+	got := 0
+	s := NewSpider(
+		BloomReqDeduplicate(NewScalableBloomFilter(100, 0.001)),
+	)
+	s.SetTaskPoolSize(1)
+	for i := 0; i < 3; i++ {
+		s.AddTask(GetReq(ts.URL+"/a"), func(ctx *Context) {
+			got += 1
+		})
+	}
+	s.AddTask(GetReq(ts.URL+"/b"), func(ctx *Context) {
+		got += 1
+	})
+	s.Run()
+	if got != 2 {
+		t.Error("wrong req got", got)
+	}
+}
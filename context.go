@@ -16,7 +16,8 @@ type Context struct {
 
 	Handlers []CtxHandlerFun
 
-	abort bool
+	abort             bool
+	noIndex, noFollow bool
 }
 
 // Abort this context to break the handler chain and stop handling
@@ -42,3 +43,23 @@ func (c *Context) AddTask(request *Request, handlers ...CtxHandlerFun) {
 		c.tasks = append(c.tasks, t)
 	}
 }
+
+// NoIndex drops the items of this context,e.g. the page has a noindex robots directive
+func (c *Context) NoIndex() {
+	c.noIndex = true
+}
+
+// IsNoIndex return whether the items of this context are dropped
+func (c *Context) IsNoIndex() bool {
+	return c.noIndex
+}
+
+// NoFollow drops the new tasks of this context,e.g. the page has a nofollow robots directive
+func (c *Context) NoFollow() {
+	c.noFollow = true
+}
+
+// IsNoFollow return whether the new tasks of this context are dropped
+func (c *Context) IsNoFollow() bool {
+	return c.noFollow
+}
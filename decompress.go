@@ -0,0 +1,96 @@
+package goribot
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+// AcceptEncoding is the Accept-Encoding header sent when BaseDownloader.AcceptCompression is enabled
+const AcceptEncoding = "gzip, deflate, br, zstd"
+
+// contentEncodings returns the encodings applied to the body in order,
+// including the gzip of a .xml.gz file or a response with gzip Content-Type
+func contentEncodings(resp *Response) []string {
+	var res []string
+	hasGzip := false
+	for _, e := range strings.Split(strings.ToLower(resp.Header.Get("Content-Encoding")), ",") {
+		e = strings.TrimSpace(e)
+		if e == "" || e == "identity" {
+			continue
+		}
+		if e == "x-gzip" {
+			e = "gzip"
+		}
+		hasGzip = hasGzip || e == "gzip"
+		res = append(res, e)
+	}
+	if !hasGzip && ((len(res) == 0 && strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "gzip")) ||
+		(resp.Req != nil && strings.HasSuffix(strings.ToLower(resp.Req.URL.Path), ".xml.gz"))) {
+		// the file itself is gzipped,so it's the first encoding applied
+		res = append([]string{"gzip"}, res...)
+	}
+	return res
+}
+
+// isZlib returns whether b starts with a zlib header,otherwise a deflate body is raw deflate data
+func isZlib(b []byte) bool {
+	return len(b) == 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// decompress wraps r with the decoders of encodings in reverse order,unknown encodings are ignored.
+// The returned function closes all the decoders.
+func decompress(r io.Reader, encodings []string) (io.Reader, func(), error) {
+	var closers []func()
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch encodings[i] {
+		case "gzip":
+			g, err := gzip.NewReader(r)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			closers = append(closers, func() { _ = g.Close() })
+			r = g
+		case "deflate":
+			br := bufio.NewReader(r)
+			head, _ := br.Peek(2)
+			if isZlib(head) {
+				z, err := zlib.NewReader(br)
+				if err != nil {
+					closeAll()
+					return nil, nil, err
+				}
+				closers = append(closers, func() { _ = z.Close() })
+				r = z
+			} else {
+				f := flate.NewReader(br)
+				closers = append(closers, func() { _ = f.Close() })
+				r = f
+			}
+		case "br":
+			r = brotli.NewReader(r)
+		case "zstd":
+			z, err := zstd.NewReader(r)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			closers = append(closers, z.Close)
+			r = z
+		default:
+			Log.Warning("unsupported content encoding", encodings[i])
+		}
+	}
+	return r, closeAll, nil
+}
@@ -0,0 +1,198 @@
+package goribot
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Resolver resolves the ip addresses of host,*net.Resolver implements it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNSStats is the statistics of DNSCache
+type DNSStats struct {
+	// Hits is the number of lookups answered by the cached results,including the static hosts
+	Hits uint64
+	// Misses is the number of lookups sent to the resolver
+	Misses uint64
+	// Shared is the number of lookups waiting for a running lookup of the same host
+	Shared uint64
+	// Errors is the number of failed lookups
+	Errors uint64
+	// Entries is the number of cached hosts
+	Entries int
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+	// done is closed when the lookup finished,so concurrent lookups of a host wait for the same one
+	done chan struct{}
+	err  error
+}
+
+// DNSCache caches the results of resolver for TTL, and overrides the hosts like /etc/hosts.
+// It's used by BaseDownloader with WithDNSCache.
+type DNSCache struct {
+	// Resolver is the resolver used on cache miss,net.DefaultResolver by default
+	Resolver Resolver
+	// TTL is how long a result is cached,results aren't cached if it's 0
+	TTL time.Duration
+	// Timeout is the timeout of a lookup sent to the resolver,10 seconds if it's 0.
+	// The lookup doesn't use the context of caller,so a cancelled request doesn't fail the others waiting for the same host.
+	Timeout time.Duration
+
+	lock                         sync.Mutex
+	hosts                        map[string][]net.IP
+	entries                      map[string]*dnsEntry
+	hits, misses, shared, errors uint64
+}
+
+// NewDNSCache creates a DNSCache with net.DefaultResolver
+func NewDNSCache(ttl time.Duration) *DNSCache {
+	return &DNSCache{
+		Resolver: net.DefaultResolver,
+		TTL:      ttl,
+		hosts:    map[string][]net.IP{},
+		entries:  map[string]*dnsEntry{},
+	}
+}
+
+// SetHost makes the host always resolved to the ips,the invalid ips are ignored
+func (s *DNSCache) SetHost(host string, ips ...string) *DNSCache {
+	var res []net.IP
+	for _, i := range ips {
+		if ip := net.ParseIP(i); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hosts[strings.ToLower(host)] = res
+	return s
+}
+
+// Lookup returns the ip addresses of host
+func (s *DNSCache) Lookup(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.ToLower(host)
+	s.lock.Lock()
+	if ips, ok := s.hosts[host]; ok {
+		s.lock.Unlock()
+		atomic.AddUint64(&s.hits, 1)
+		return ips, nil
+	}
+	e, ok := s.entries[host]
+	if ok {
+		select {
+		case <-e.done:
+			if e.err != nil || time.Now().After(e.expires) {
+				ok = false
+			} else {
+				atomic.AddUint64(&s.hits, 1)
+			}
+		default: // a lookup is running
+			atomic.AddUint64(&s.shared, 1)
+		}
+	}
+	if !ok {
+		e = &dnsEntry{done: make(chan struct{})}
+		s.entries[host] = e
+		atomic.AddUint64(&s.misses, 1)
+		go s.resolve(host, e, s.TTL, s.Timeout)
+	}
+	s.lock.Unlock()
+	select {
+	case <-e.done:
+		return e.ips, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve looks up the host by resolver and records the result to e
+func (s *DNSCache) resolve(host string, e *dnsEntry, ttl, timeout time.Duration) {
+	r := s.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := r.LookupIPAddr(ctx, host)
+	for _, a := range addrs {
+		e.ips = append(e.ips, a.IP)
+	}
+	e.err = err
+	e.expires = time.Now().Add(ttl)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	if err != nil || ttl <= 0 {
+		s.lock.Lock()
+		if s.entries[host] == e {
+			delete(s.entries, host)
+		}
+		s.lock.Unlock()
+	}
+	close(e.done)
+}
+
+// Stats returns the statistics of cache
+func (s *DNSCache) Stats() DNSStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return DNSStats{
+		Hits:    atomic.LoadUint64(&s.hits),
+		Misses:  atomic.LoadUint64(&s.misses),
+		Shared:  atomic.LoadUint64(&s.shared),
+		Errors:  atomic.LoadUint64(&s.errors),
+		Entries: len(s.entries),
+	}
+}
+
+// Clear removes all the cached results,the static hosts are kept
+func (s *DNSCache) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = map[string]*dnsEntry{}
+}
+
+// WithDNSCache makes the downloader resolve hosts by the DNSCache
+func WithDNSCache(c *DNSCache) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.dns = c
+	}
+}
+
+// dialContext dials addr,resolving the host by the DNSCache if set and trying the ips in order
+func (s *BaseDownloader) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.dns == nil {
+		return s.dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return s.dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := s.dns.Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	err = &net.DNSError{Err: "no suitable address found", Name: host}
+	for _, ip := range ips {
+		if (network == "tcp4" && ip.To4() == nil) || (network == "tcp6" && ip.To4() != nil) {
+			continue
+		}
+		var conn net.Conn
+		if conn, err = s.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
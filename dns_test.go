@@ -0,0 +1,122 @@
+package goribot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testResolver struct {
+	calls int32
+}
+
+func (s *testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if host == "goribot.test" {
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestDNSCache(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.Host)
+	}))
+	defer ts.Close()
+	port := ts.URL[strings.LastIndex(ts.URL, ":"):]
+
+	r := &testResolver{}
+	c := NewDNSCache(time.Minute).SetHost("static.test", "127.0.0.1")
+	c.Resolver = r
+	d := NewBaseDownloader(WithDNSCache(c), WithKeepAlive(-1))
+	for _, host := range []string{"goribot.test", "goribot.test", "static.test"} {
+		resp, err := d.Do(Get("http://" + host + port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != host+port {
+			t.Error("wrong response", resp.Text)
+		}
+	}
+	if _, err := d.Do(Get("http://unknown.test" + port)); err == nil {
+		t.Error("unknown host got no error")
+	}
+	if r.calls != 2 {
+		t.Error("wrong resolver calls", r.calls)
+	}
+	if s := c.Stats(); s.Hits != 2 || s.Misses != 2 || s.Errors != 1 || s.Entries != 1 {
+		t.Error("wrong stats", s)
+	}
+
+	c.TTL = time.Millisecond
+	c.Clear()
+	_, _ = c.Lookup(context.Background(), "goribot.test")
+	time.Sleep(5 * time.Millisecond)
+	if ips, err := c.Lookup(context.Background(), "goribot.test"); err != nil || len(ips) != 1 || r.calls != 4 {
+		t.Error("expired result is used", ips, err, r.calls)
+	}
+}
+
+type blockingResolver struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&s.calls, 1)
+	close(s.started)
+	select {
+	case <-s.release:
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDNSCacheSharedLookup(t *testing.T) {
+	r := &blockingResolver{started: make(chan struct{}), release: make(chan struct{})}
+	c := NewDNSCache(time.Minute)
+	c.Resolver = r
+
+	// the first caller gives up,the one waiting for the same lookup still gets the result
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.Lookup(ctx, "goribot.test")
+		first <- err
+	}()
+	<-r.started
+	second := make(chan error, 1)
+	go func() {
+		ips, err := c.Lookup(context.Background(), "goribot.test")
+		if err == nil && len(ips) != 1 {
+			err = errors.New("wrong ips")
+		}
+		second <- err
+	}()
+	for c.Stats().Shared != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Error("cancelled lookup got", err)
+	}
+	close(r.release)
+	if err := <-second; err != nil {
+		t.Error("shared lookup failed with the first caller", err)
+	}
+	if _, err := c.Lookup(context.Background(), "goribot.test"); err != nil || r.calls != 1 {
+		t.Error("result isn't cached", err, r.calls)
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 1 || s.Shared != 1 || s.Errors != 0 {
+		t.Error("wrong stats", s)
+	}
+}
@@ -0,0 +1,142 @@
+package goribot
+
+import (
+	"bytes"
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"mime"
+	"strings"
+)
+
+// EncodingSource is where the character encoding of a response comes from
+type EncodingSource string
+
+const (
+	// EncodingSourceBOM means the encoding is decided by the byte order mark
+	EncodingSourceBOM EncodingSource = "bom"
+	// EncodingSourceMeta means the encoding is declared by <meta charset> or <meta http-equiv> in html,
+	// or the xml declaration in xml
+	EncodingSourceMeta EncodingSource = "meta"
+	// EncodingSourceHeader means the encoding is the charset in Content-Type header
+	EncodingSourceHeader EncodingSource = "header"
+	// EncodingSourceRequest means the encoding is set by Request.ResponseCharacterEncoding
+	EncodingSourceRequest EncodingSource = "request"
+	// EncodingSourceDetect means the encoding is guessed from the content statistically
+	EncodingSourceDetect EncodingSource = "detect"
+)
+
+// sniffLen is the number of bytes scanned for the encoding declared in document
+const sniffLen = 1024
+
+var boms = []struct {
+	bom  []byte
+	name string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "utf-8"},
+	{[]byte{0xFE, 0xFF}, "utf-16be"},
+	{[]byte{0xFF, 0xFE}, "utf-16le"},
+}
+
+// lookupEncoding returns the encoding and its canonical name of a label,with a few aliases used by chardet
+func lookupEncoding(label string) (encoding.Encoding, string) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" {
+		return nil, ""
+	}
+	if e, name := charset.Lookup(label); e != nil {
+		return e, name
+	}
+	return charset.Lookup(strings.Replace(label, "-", "", -1))
+}
+
+// htmlMetaCharset returns the charset declared by <meta charset> or <meta http-equiv="Content-Type"> in b
+func htmlMetaCharset(b []byte) string {
+	if len(b) > sniffLen {
+		b = b[:sniffLen]
+	}
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "meta" {
+				continue
+			}
+			var cs, content string
+			httpEquiv := false
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				switch strings.ToLower(string(k)) {
+				case "charset":
+					cs = string(v)
+				case "http-equiv":
+					httpEquiv = strings.EqualFold(string(v), "content-type")
+				case "content":
+					content = string(v)
+				}
+			}
+			if cs == "" && httpEquiv {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					cs = params["charset"]
+				}
+			}
+			if cs != "" {
+				return cs
+			}
+		}
+	}
+}
+
+// sniffEncoding decides the character encoding of the response body in the order of
+// BOM, Request.ResponseCharacterEncoding, document declaration (html meta or xml declaration),
+// Content-Type header and statistical detection.
+// It returns the length of BOM to be skipped.
+func (s *Response) sniffEncoding() (e encoding.Encoding, name string, source EncodingSource, bomLen int, err error) {
+	for _, b := range boms {
+		if bytes.HasPrefix(s.Body, b.bom) {
+			e, name = lookupEncoding(b.name)
+			return e, name, EncodingSourceBOM, len(b.bom), nil
+		}
+	}
+
+	// an explicit encoding of request overrides everything but BOM
+	if s.Req != nil {
+		if e, name = lookupEncoding(s.Req.ResponseCharacterEncoding); e != nil {
+			return e, name, EncodingSourceRequest, 0, nil
+		}
+	}
+
+	declared := ""
+	if s.IsHTML() {
+		declared = htmlMetaCharset(s.Body)
+	} else if s.IsXML() {
+		declared = xmlDeclEncoding(s.Body)
+	}
+	if e, name = lookupEncoding(declared); e != nil {
+		// a document can't declare itself as utf-16 since the declaration is read as ascii
+		if strings.HasPrefix(name, "utf-16") {
+			e, name = lookupEncoding("utf-8")
+		}
+		return e, name, EncodingSourceMeta, 0, nil
+	}
+
+	if _, params, err := mime.ParseMediaType(s.Header.Get("Content-Type")); err == nil {
+		if e, name = lookupEncoding(params["charset"]); e != nil {
+			return e, name, EncodingSourceHeader, 0, nil
+		}
+	}
+
+	r, err := chardet.NewTextDetector().DetectBest(s.Body)
+	if err != nil {
+		return nil, "", "", 0, err
+	}
+	if e, name = lookupEncoding(r.Charset); e == nil {
+		e, name, _ = charset.DetermineEncoding(s.Body, "")
+	}
+	return e, name, EncodingSourceDetect, 0, nil
+}
@@ -128,6 +128,8 @@ func Retry(maxTimes int, okcode ...int) func(s *Spider) {
 }
 
 // RobotsTxt is an extension can parse the robots.txt and follow it
+//
+// Deprecated: it only follows the robots.txt of baseUrl for all hosts,use RobotsTxtManager instead.
 func RobotsTxt(baseUrl, ua string) func(s *Spider) {
 	if !strings.HasSuffix(baseUrl, "/") {
 		baseUrl += "/"
@@ -284,26 +286,88 @@ func AddCookieToJar(urlAddr string, cookies ...*http.Cookie) func(s *Spider) {
 	}
 }
 
-// ReqDeduplicate is an extension can deduplicate new task
-func ReqDeduplicate() func(s *Spider) {
+// ReqDeduplicate is an extension can deduplicate new task.
+// The identity of request is computed by the Fingerprinter if given, otherwise by GetRequestHash.
+func ReqDeduplicate(fp ...*Fingerprinter) func(s *Spider) {
 	CrawledHash := map[[md5.Size]byte]struct{}{}
 	lock := sync.Mutex{}
+	f := getFingerprinter(fp)
 	return func(s *Spider) {
-		s.OnAdd(func(ctx *Context, t *Task) *Task {
-			if _, ok := t.Request.Meta["RetryTimes"]; ok {
-				return t
-			}
-			has := GetRequestHash(t.Request)
-
+		deduplicate(s, f, func(has [md5.Size]byte) bool {
 			lock.Lock()
 			defer lock.Unlock()
 
 			if _, ok := CrawledHash[has]; ok {
-				return nil
+				return true
 			}
 
 			CrawledHash[has] = struct{}{}
+			return false
+		})
+	}
+}
+
+// fingerprintHeader returns the headers of request when it was added,
+// so the headers set later like User-Agent, Cookie and Accept-Encoding aren't hashed for the final and canonical url
+func fingerprintHeader(req *Request) http.Header {
+	if h, ok := req.Meta["FingerprintHeader"].(http.Header); ok {
+		return h
+	}
+	return req.Header
+}
+
+// deduplicate drops the new tasks that seen reports as crawled, seen should record the hash as well.
+// Retried tasks are always kept.
+func deduplicate(s *Spider, f *Fingerprinter, seen func(has [md5.Size]byte) bool) {
+	s.OnAdd(func(ctx *Context, t *Task) *Task {
+		if _, ok := t.Request.Meta["RetryTimes"]; ok {
 			return t
+		}
+		if seen(f.Fingerprint(t.Request)) {
+			return nil
+		}
+		if f.FinalURL || f.Canonical {
+			t.Request.Meta["FingerprintHeader"] = t.Request.Header.Clone()
+		}
+		return t
+	})
+	if f.FinalURL {
+		s.OnResp(func(ctx *Context) {
+			if len(ctx.Resp.Redirects) == 0 {
+				return
+			}
+			final := Get(ctx.Resp.Request.URL.String())
+			final.Header = fingerprintHeader(ctx.Req)
+			if seen(f.Fingerprint(final)) {
+				ctx.Abort()
+			}
+		})
+	}
+	if f.Canonical {
+		s.OnResp(func(ctx *Context) {
+			u := ctx.Resp.Canonical()
+			if u == nil || ctx.IsAborted() {
+				return
+			}
+			header := fingerprintHeader(ctx.Req)
+			canonical := Get(u.String())
+			canonical.Header = header
+			has := f.Fingerprint(canonical)
+			origin := *ctx.Req
+			origin.Header = header
+			if has == f.Fingerprint(&origin) {
+				return
+			}
+			if f.FinalURL && len(ctx.Resp.Redirects) > 0 {
+				final := Get(ctx.Resp.Request.URL.String())
+				final.Header = header
+				if has == f.Fingerprint(final) {
+					return
+				}
+			}
+			if seen(has) {
+				ctx.Abort()
+			}
 		})
 	}
 }
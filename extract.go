@@ -0,0 +1,311 @@
+package goribot
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/tidwall/gjson"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrExtractTarget = errors.New("extract target must be a non-nil pointer to struct")
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// extractNode is where fields are extracted from,a html selection,a json result or the whole response
+type extractNode struct {
+	sel  *goquery.Selection
+	json *gjson.Result
+	// text is the raw content of the whole response,used when no css or json tag is given
+	text  string
+	isTop bool
+}
+
+func (s extractNode) value(attr string) string {
+	switch {
+	case s.isTop && attr == "":
+		return s.text
+	case s.sel != nil && attr != "":
+		return s.sel.AttrOr(attr, "")
+	case s.sel != nil:
+		return strings.TrimSpace(s.sel.Text())
+	case s.json != nil:
+		return s.json.String()
+	}
+	return s.text
+}
+
+type extractor struct {
+	base *url.URL
+}
+
+// Extract fills the struct v by the struct tags of its fields:
+//
+//	css:"h1.title"      selects the html elements by css selector,empty selector means the current element
+//	attr:"href"         uses the attribute instead of the text of element
+//	json:"data.items"   selects the json value by gjson path,the options after comma are ignored
+//	regexp:"(\d+)"      extracts from the value by regexp,the first group is used if exists
+//	layout:"2006-01-02" is the layout of time.Time fields,time.RFC3339 by default
+//
+// The json tag is skipped when the response isn't json (see Response.IsJSON),
+// so the structs with json tags for encoding work with html.
+// Nested struct and slice fields are supported, string values are converted to the type of field,
+// url.URL fields are resolved against the url of response.
+func (s *Response) Extract(v interface{}) error {
+	n := extractNode{text: s.Text, isTop: true}
+	if s.Dom != nil {
+		n.sel = s.Dom.Selection
+	}
+	if s.IsJSON() {
+		j := gjson.Parse(s.Text)
+		n.json = &j
+	}
+	return (&extractor{base: s.baseURL()}).extract(n, v)
+}
+
+// Extract fills the struct v with the response,see Response.Extract
+func (c *Context) Extract(v interface{}) error {
+	return c.Resp.Extract(v)
+}
+
+// UnmarshalSelection fills the struct v with the html selection,see Response.Extract.
+// Urls are resolved against base,which could be nil.
+func UnmarshalSelection(sel *goquery.Selection, v interface{}, base *url.URL) error {
+	return (&extractor{base: base}).extract(extractNode{sel: sel}, v)
+}
+
+// UnmarshalGJSON fills the struct v with the json result,see Response.Extract.
+// Urls are resolved against base,which could be nil.
+func UnmarshalGJSON(j gjson.Result, v interface{}, base *url.URL) error {
+	return (&extractor{base: base}).extract(extractNode{json: &j}, v)
+}
+
+func (s *extractor) extract(n extractNode, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrExtractTarget
+	}
+	return s.decodeStruct(n, rv.Elem())
+}
+
+func isPlainStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t != urlType && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func (s *extractor) decodeStruct(n extractNode, rv reflect.Value) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if err := s.decodeField(n, f, rv.Field(i)); err != nil {
+			return fmt.Errorf("extract field %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *extractor) decodeField(n extractNode, f reflect.StructField, fv reflect.Value) error {
+	css, hasCSS := f.Tag.Lookup("css")
+	path, hasJSON := f.Tag.Lookup("json")
+	if i := strings.Index(path, ","); i >= 0 {
+		path = path[:i]
+	}
+	hasJSON = hasJSON && path != "-" && n.json != nil
+	attr, layout := f.Tag.Get("attr"), f.Tag.Get("layout")
+	var re *regexp.Regexp
+	if r := f.Tag.Get("regexp"); r != "" {
+		var err error
+		if re, err = regexp.Compile(r); err != nil {
+			return err
+		}
+	}
+
+	t := f.Type
+	isSlice := t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+	if isSlice {
+		t = t.Elem()
+	}
+	elem := t
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+
+	var nodes []extractNode
+	switch {
+	case hasCSS:
+		if n.sel == nil {
+			return errors.New("css tag needs a html document")
+		}
+		sel := n.sel
+		if css != "" {
+			sel = sel.Find(css)
+		} else if n.isTop {
+			n.isTop = false
+			nodes = append(nodes, n)
+			break
+		}
+		sel.Each(func(i int, sel *goquery.Selection) {
+			nodes = append(nodes, extractNode{sel: sel})
+		})
+	case hasJSON:
+		r := *n.json
+		if path != "" {
+			r = r.Get(path)
+		}
+		if !r.Exists() {
+			break
+		}
+		if isSlice && r.IsArray() {
+			for _, i := range r.Array() {
+				i := i
+				nodes = append(nodes, extractNode{json: &i})
+			}
+		} else {
+			nodes = append(nodes, extractNode{json: &r})
+		}
+	case re != nil:
+		nodes = append(nodes, n)
+	default:
+		if isPlainStruct(f.Type) {
+			return s.decodeStruct(n, fv)
+		}
+		return nil
+	}
+
+	var values []reflect.Value
+	for _, node := range nodes {
+		if isPlainStruct(elem) {
+			v := reflect.New(elem)
+			if err := s.decodeStruct(node, v.Elem()); err != nil {
+				return err
+			}
+			values = append(values, v.Elem())
+			continue
+		}
+		strs := []string{node.value(attr)}
+		if re != nil {
+			strs = strs[:0]
+			for _, m := range re.FindAllStringSubmatch(node.value(attr), -1) {
+				strs = append(strs, m[len(m)-1])
+				if !isSlice {
+					break
+				}
+			}
+		}
+		for _, str := range strs {
+			v := reflect.New(elem)
+			if ok, err := s.decodeValue(str, v.Elem(), layout); err != nil {
+				return err
+			} else if ok {
+				values = append(values, v.Elem())
+			}
+		}
+		if !isSlice && len(values) > 0 {
+			break
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	if !isSlice {
+		fv.Set(toType(values[0], f.Type))
+		return nil
+	}
+	res := reflect.MakeSlice(f.Type, 0, len(values))
+	for _, v := range values {
+		res = reflect.Append(res, toType(v, t))
+	}
+	fv.Set(res)
+	return nil
+}
+
+// toType converts v to t by taking address as many times as t is pointer
+func toType(v reflect.Value, t reflect.Type) reflect.Value {
+	if t.Kind() != reflect.Ptr {
+		return v
+	}
+	p := reflect.New(t.Elem())
+	p.Elem().Set(toType(v, t.Elem()))
+	return p
+}
+
+// decodeValue converts str to the type of v and sets it,returns false if str is empty for non-string v
+func (s *extractor) decodeValue(str string, v reflect.Value, layout string) (bool, error) {
+	if v.Kind() == reflect.String {
+		v.SetString(str)
+		return true, nil
+	}
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return false, nil
+	}
+	switch {
+	case v.Type() == timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, str)
+		if err != nil {
+			return false, err
+		}
+		v.Set(reflect.ValueOf(t))
+		return true, nil
+	case v.Type() == urlType:
+		u, err := url.Parse(str)
+		if err != nil {
+			return false, err
+		}
+		if s.base != nil {
+			u = s.base.ResolveReference(u)
+		}
+		v.Set(reflect.ValueOf(*u))
+		return true, nil
+	case reflect.PtrTo(v.Type()).Implements(textUnmarshalerType):
+		return true, v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+	}
+	num := strings.Replace(str, ",", "", -1)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(num, 10, v.Type().Bits())
+		if err != nil {
+			return false, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(num, 10, v.Type().Bits())
+		if err != nil {
+			return false, err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(num, v.Type().Bits())
+		if err != nil {
+			return false, err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return false, err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.SetBytes([]byte(str))
+	default:
+		return false, fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return true, nil
+}
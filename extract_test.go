@@ -0,0 +1,129 @@
+package goribot
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type extractTestItem struct {
+	Name  string   `css:"a"`
+	Link  *url.URL `css:"a" attr:"href"`
+	Price float64  `css:".price" regexp:"([\\d.]+)"`
+}
+
+type extractTestInner struct {
+	Views int `css:"#views"`
+}
+
+type extractTestPage struct {
+	Title    string            `css:"h1.title"`
+	Views    int               `css:"#views"`
+	Date     time.Time         `css:"#date" layout:"2006-01-02"`
+	Tags     []string          `css:".tag"`
+	Items    []extractTestItem `css:"li.item"`
+	Canon    url.URL           `css:"link[rel=canonical]" attr:"href"`
+	Keywords []string          `regexp:"kw-(\\w+)"`
+	Missing  *int              `css:"#missing"`
+	Inner    extractTestInner  `json:"inner"`
+}
+
+type extractTestJSON struct {
+	Total int `json:"data.total"`
+	Users []struct {
+		Name string `json:"name"`
+		Age  uint8  `json:"age"`
+	} `json:"data.users"`
+	Home url.URL `json:"data.home"`
+}
+
+func TestExtract(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"data":{"total":2,"home":"/u/","users":[{"name":"a","age":18},{"name":"b","age":20}]}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, `<html><head><link rel="canonical" href="/page"></head><body>
+<h1 class="title"> Hello goribot </h1><span id="views">1,234</span><span id="date">2020-03-01</span>
+<span class="tag">go</span><span class="tag">crawler</span>
+<ul>
+<li class="item"><a href="/item/1">one</a><span class="price">$1.5</span></li>
+<li class="item"><a href="item/2">two</a><span class="price">$20</span></li>
+</ul>
+<p>kw-spider kw-golang</p>
+</body></html>`)
+	}))
+	defer ts.Close()
+
+	gotHTML, gotJSON := false, false
+	var errs []error
+	lock := sync.Mutex{}
+	s := NewSpider()
+	s.AddTask(GetReq(ts.URL+"/list/"), func(ctx *Context) {
+		gotHTML = true
+		p := extractTestPage{}
+		if err := ctx.Extract(&p); err != nil {
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, err)
+			return
+		}
+		if p.Title != "Hello goribot" || p.Views != 1234 || p.Date.Day() != 1 || len(p.Tags) != 2 || p.Missing != nil {
+			t.Error("wrong data", p)
+		}
+		if len(p.Items) != 2 || p.Items[1].Name != "two" || p.Items[1].Price != 20 ||
+			p.Items[0].Link.String() != ts.URL+"/item/1" || p.Items[1].Link.String() != ts.URL+"/list/item/2" {
+			t.Error("wrong items", p.Items)
+		}
+		if p.Canon.String() != ts.URL+"/page" {
+			t.Error("wrong canonical", p.Canon.String())
+		}
+		if p.Inner.Views != 1234 {
+			t.Error("nested struct with json tag isn't extracted from html", p.Inner)
+		}
+		if len(p.Keywords) != 2 || p.Keywords[1] != "golang" {
+			t.Error("wrong keywords", p.Keywords)
+		}
+	})
+	s.AddTask(GetReq(ts.URL+"/json"), func(ctx *Context) {
+		gotJSON = true
+		j := extractTestJSON{}
+		if err := ctx.Extract(&j); err != nil {
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, err)
+			return
+		}
+		if j.Total != 2 || len(j.Users) != 2 || j.Users[1].Name != "b" || j.Users[1].Age != 20 || j.Home.String() != ts.URL+"/u/" {
+			t.Error("wrong data", j)
+		}
+		if err := ctx.Extract(j); err != ErrExtractTarget {
+			t.Error("wrong error", err)
+		}
+	})
+	s.Run()
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if !gotHTML || !gotJSON {
+		t.Error("didn't get data")
+	}
+
+	// json tags for encoding don't break the extraction from html
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<a href="/a">link</a>`))
+	e := struct {
+		Name string `css:"a" json:"name,omitempty"`
+		Note string `json:"note"`
+	}{}
+	if err := UnmarshalSelection(doc.Selection, &e, nil); err != nil || e.Name != "link" || e.Note != "" {
+		t.Error("wrong extraction with json tags", e, err)
+	}
+}
@@ -0,0 +1,194 @@
+package goribot
+
+import (
+	"crypto/md5"
+	"github.com/gobwas/glob"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TrackingParams is a list of globs matching the common tracking query params,
+// could be used as Fingerprinter.DropParams
+var TrackingParams = []string{"utm_*", "gclid", "fbclid", "yclid", "msclkid", "mc_cid", "mc_eid", "_ga"}
+
+// Fingerprinter canonicalizes the url and computes the hash of a request,which decides whether two requests are the same.
+// The zero value hashes the url, all headers, cookies and body, works like GetRequestHash.
+type Fingerprinter struct {
+	// IgnoreHeaders are the headers skipped when hashing.Use "*" to skip all headers.
+	IgnoreHeaders []string
+	// IgnoreCookies skips the cookies of request
+	IgnoreCookies bool
+	// IgnoreBody skips the body of request
+	IgnoreBody bool
+	// DropParams removes the query params whose key matches one of these globs,e.g. "utm_*"
+	DropParams []string
+	// KeepParams removes the query params whose key matches none of these globs if set
+	KeepParams []string
+	// StripQuery removes the whole query of url
+	StripQuery bool
+	// KeepParamOrder keeps the query params in origin order instead of sorting them
+	KeepParamOrder bool
+	// StripTrailingSlash removes the trailing slash of path,so "/a/" and "/a" are the same
+	StripTrailingSlash bool
+	// StripDefaultPort removes ":80" from http urls and ":443" from https urls
+	StripDefaultPort bool
+	// FinalURL makes the deduplicate extensions check the final url of redirected responses as well,
+	// the context is aborted if the final url was crawled.
+	FinalURL bool
+	// Canonical makes the deduplicate extensions check the canonical url of html responses as well,see Response.Canonical.
+	// The canonical url is recorded as crawled, and the context is aborted if it was crawled.
+	Canonical bool
+
+	once                   sync.Once
+	ignoreHeaders          map[string]struct{}
+	dropParams, keepParams []glob.Glob
+}
+
+func (s *Fingerprinter) compile() {
+	s.once.Do(func() {
+		s.ignoreHeaders = map[string]struct{}{}
+		for _, h := range s.IgnoreHeaders {
+			s.ignoreHeaders[http.CanonicalHeaderKey(h)] = struct{}{}
+		}
+		if s.IgnoreCookies {
+			s.ignoreHeaders["Cookie"] = struct{}{}
+		}
+		for _, p := range s.DropParams {
+			s.dropParams = append(s.dropParams, glob.MustCompile(p))
+		}
+		for _, p := range s.KeepParams {
+			s.keepParams = append(s.keepParams, glob.MustCompile(p))
+		}
+	})
+}
+
+func (s *Fingerprinter) keepParam(k string) bool {
+	for _, g := range s.dropParams {
+		if g.Match(k) {
+			return false
+		}
+	}
+	if len(s.keepParams) == 0 {
+		return true
+	}
+	for _, g := range s.keepParams {
+		if g.Match(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonicalize returns the canonical form of url,without fragment
+func (s *Fingerprinter) Canonicalize(u *url.URL) string {
+	s.compile()
+	res := strings.ToLower(u.Scheme) + "://"
+	if u.User != nil {
+		res += u.User.String() + "@"
+	}
+	host := strings.ToLower(u.Host)
+	if s.StripDefaultPort {
+		port := u.Port()
+		if (port == "80" && strings.EqualFold(u.Scheme, "http")) || (port == "443" && strings.EqualFold(u.Scheme, "https")) {
+			host = strings.TrimSuffix(host, ":"+port)
+		}
+	}
+	res += host
+	path := u.EscapedPath()
+	if s.StripTrailingSlash {
+		path = strings.TrimRight(path, "/")
+	}
+	if path != "" && path[0] != '/' {
+		res += "/"
+	}
+	res += path
+	if u.RawQuery != "" && !s.StripQuery {
+		var params [][2]string
+		for _, p := range strings.Split(u.RawQuery, "&") {
+			if p == "" {
+				continue
+			}
+			kv := strings.SplitN(p, "=", 2)
+			k, err := url.QueryUnescape(kv[0])
+			if err != nil {
+				k = kv[0]
+			}
+			v := ""
+			if len(kv) == 2 {
+				if v, err = url.QueryUnescape(kv[1]); err != nil {
+					v = kv[1]
+				}
+			}
+			if s.keepParam(k) {
+				params = append(params, [2]string{k, v})
+			}
+		}
+		if !s.KeepParamOrder {
+			sort.SliceStable(params, func(i, j int) bool {
+				if params[i][0] != params[j][0] {
+					return params[i][0] < params[j][0]
+				}
+				return params[i][1] < params[j][1]
+			})
+		}
+		var QueryStrList []string
+		for _, p := range params {
+			QueryStrList = append(QueryStrList, url.QueryEscape(p[0])+"="+url.QueryEscape(p[1]))
+		}
+		if len(QueryStrList) > 0 {
+			res += "?" + strings.Join(QueryStrList, "&")
+		}
+	}
+	return res
+}
+
+// Fingerprint returns a hash of the canonical url,header,cookie and body data from a request
+func (s *Fingerprinter) Fingerprint(r *Request) [md5.Size]byte {
+	s.compile()
+	UrlStr := s.Canonicalize(r.URL)
+
+	HeaderStr := ""
+	if _, ok := s.ignoreHeaders["*"]; !ok {
+		var HeaderK []string
+		for k := range r.Header {
+			if _, ok := s.ignoreHeaders[http.CanonicalHeaderKey(k)]; !ok {
+				HeaderK = append(HeaderK, k)
+			}
+		}
+		sort.Strings(HeaderK)
+		var HeaderStrList []string
+		for _, k := range HeaderK {
+			val := append([]string{}, r.Header[k]...)
+			sort.Strings(val)
+			for _, v := range val {
+				HeaderStrList = append(HeaderStrList, url.QueryEscape(k)+"="+url.QueryEscape(v))
+			}
+		}
+		HeaderStr = strings.Join(HeaderStrList, "&")
+	}
+
+	CookieStr := ""
+	if !s.IgnoreCookies {
+		var Cookie []string
+		for _, i := range r.Cookies() {
+			Cookie = append(Cookie, i.Name+"="+i.Value)
+		}
+		CookieStr = strings.Join(Cookie, "&")
+	}
+
+	data := []byte(strings.Join([]string{UrlStr, HeaderStr, CookieStr}, "@#@"))
+	if !s.IgnoreBody {
+		data = append(data, r.GetBody()...)
+	}
+	return md5.Sum(data)
+}
+
+func getFingerprinter(fp []*Fingerprinter) *Fingerprinter {
+	if len(fp) > 0 && fp[0] != nil {
+		return fp[0]
+	}
+	return &Fingerprinter{}
+}
@@ -0,0 +1,43 @@
+package goribot
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestFingerprinter(t *testing.T) {
+	f := &Fingerprinter{
+		IgnoreHeaders:      []string{"user-agent", "Referer"},
+		DropParams:         TrackingParams,
+		StripTrailingSlash: true,
+		StripDefaultPort:   true,
+	}
+	u, _ := url.Parse("HTTPS://Example.com:443/a/?b=2&utm_source=x&a=1#frag")
+	if c := f.Canonicalize(u); c != "https://example.com/a?a=1&b=2" {
+		t.Error("wrong canonical url", c)
+	}
+
+	if f.Fingerprint(Get("https://example.com/a?a=1").SetUA("A")) !=
+		f.Fingerprint(Get("https://example.com/a/?utm_medium=y&a=1").SetUA("B").SetHeader("Referer", "https://example.com")) {
+		t.Error("ignored parts changed the fingerprint")
+	}
+	if f.Fingerprint(Get("https://example.com/a").SetHeader("Accept", "text/html")) ==
+		f.Fingerprint(Get("https://example.com/a")) {
+		t.Error("headers not hashed")
+	}
+	if GetRequestHash(PostRawReq("https://example.com/", []byte("a=1"))) ==
+		GetRequestHash(PostRawReq("https://example.com/", []byte("a=2"))) {
+		t.Error("body not hashed")
+	}
+	if f := (&Fingerprinter{IgnoreCookies: true}); f.Fingerprint(Get("https://example.com/").AddCookie(&http.Cookie{Name: "a", Value: "1"})) !=
+		f.Fingerprint(Get("https://example.com/")) {
+		t.Error("cookies not ignored")
+	}
+
+	req := PostRawReq("https://example.com/", []byte("hello"))
+	_ = req.GetBody()
+	if string(req.GetBody()) != "hello" {
+		t.Error("wrong body", string(req.GetBody()))
+	}
+}
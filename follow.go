@@ -0,0 +1,151 @@
+package goribot
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gobwas/glob"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const linkSelector = "a[href], area[href]"
+
+// followLinksCount makes the meta key of each FollowLinks unique
+var followLinksCount int32
+
+// LinkRule describes which links FollowLinks picks from a html response and how to handle them
+type LinkRule struct {
+	// Allow and Deny are regexps matching the absolute url of a link.
+	// A link must match one of Allow (if any) and none of Deny.
+	Allow, Deny []string
+	// AllowGlob and DenyGlob work like Allow and Deny but use glob expressions
+	AllowGlob, DenyGlob []string
+	// RestrictCSS limits the extraction to links inside the elements selected by these css selectors
+	RestrictCSS []string
+	// Handlers are attached to the tasks created from the matched links
+	Handlers []CtxHandlerFun
+	// Follow sets whether keep extracting links from the responses got by this rule
+	Follow bool
+	// IgnoreNofollow makes links with rel="nofollow" be extracted as well
+	IgnoreNofollow bool
+
+	allow, deny         []*regexp.Regexp
+	allowGlob, denyGlob []glob.Glob
+}
+
+func (s *LinkRule) compile() {
+	for _, i := range s.Allow {
+		s.allow = append(s.allow, regexp.MustCompile(i))
+	}
+	for _, i := range s.Deny {
+		s.deny = append(s.deny, regexp.MustCompile(i))
+	}
+	for _, i := range s.AllowGlob {
+		s.allowGlob = append(s.allowGlob, glob.MustCompile(i))
+	}
+	for _, i := range s.DenyGlob {
+		s.denyGlob = append(s.denyGlob, glob.MustCompile(i))
+	}
+}
+
+// Match returns whether the absolute url is allowed by the rule
+func (s *LinkRule) Match(u string) bool {
+	match := len(s.allow) == 0 && len(s.allowGlob) == 0
+	for _, r := range s.allow {
+		if match = r.MatchString(u); match {
+			break
+		}
+	}
+	if !match {
+		for _, g := range s.allowGlob {
+			if match = g.Match(u); match {
+				break
+			}
+		}
+	}
+	if !match {
+		return false
+	}
+	for _, r := range s.deny {
+		if r.MatchString(u) {
+			return false
+		}
+	}
+	for _, g := range s.denyGlob {
+		if g.Match(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// Links returns the absolute urls of links in the dom selected by the rule, with fragment stripped
+func (s *LinkRule) Links(dom *goquery.Document, base *url.URL) []string {
+	sel := dom.Find(linkSelector)
+	if len(s.RestrictCSS) > 0 {
+		sel = sel.FilterFunction(func(i int, a *goquery.Selection) bool {
+			for _, css := range s.RestrictCSS {
+				if a.Closest(css).Length() > 0 {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	var res []string
+	sel.Each(func(i int, a *goquery.Selection) {
+		if !s.IgnoreNofollow && hasToken(a.AttrOr("rel", ""), "nofollow") {
+			return
+		}
+		u, err := base.Parse(strings.TrimSpace(a.AttrOr("href", "")))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		u.Fragment = ""
+		res = append(res, u.String())
+	})
+	return res
+}
+
+// hasToken reports whether the space separated list contains the token, case insensitive
+func hasToken(list, token string) bool {
+	for _, i := range strings.Fields(strings.ToLower(list)) {
+		if i == token {
+			return true
+		}
+	}
+	return false
+}
+
+// FollowLinks is an extension extracts links from html responses and creates new tasks by the rules.
+// A link is handled by the first rule it matches.
+func FollowLinks(rules ...*LinkRule) func(s *Spider) {
+	for _, r := range rules {
+		r.compile()
+	}
+	// the index of rule got the link is kept in meta,so the key must be unique among FollowLinks
+	key := fmt.Sprintf("FollowLinksRule_%d", atomic.AddInt32(&followLinksCount, 1))
+	return func(s *Spider) {
+		s.OnResp(func(ctx *Context) {
+			if ctx.Resp.Dom == nil {
+				return
+			}
+			if k, ok := ctx.Req.Meta[key].(int); ok && k < len(rules) && !rules[k].Follow {
+				return
+			}
+			base := ctx.Resp.baseURL()
+			seen := map[string]struct{}{}
+			for k, r := range rules {
+				for _, u := range r.Links(ctx.Resp.Dom, base) {
+					if _, ok := seen[u]; ok || !r.Match(u) {
+						continue
+					}
+					seen[u] = struct{}{}
+					ctx.AddTask(Get(u).WithMeta(key, k), r.Handlers...)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package goribot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestFollowLinks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/":
+			_, _ = fmt.Fprint(w, `<html><body>
+<div id="list">
+<a href="/item/1#top">1</a>
+<a href="item/2">2</a>
+<a href="/item/3" rel="nofollow">3</a>
+<a href="mailto:someone@example.com">mail</a>
+<a href="/item/deny">deny</a>
+</div>
+<a href="/item/4">out of list</a>
+<a href="/page/2">next</a>
+</body></html>`)
+		case "/page/2":
+			_, _ = fmt.Fprint(w, `<html><body><div id="list"><a href="/item/5">5</a></div><a href="/page/3">next</a></body></html>`)
+		case "/page/3":
+			_, _ = fmt.Fprint(w, `<html><body>end</body></html>`)
+		default:
+			_, _ = fmt.Fprint(w, `<html><body><div id="list"><a href="/item/6">6</a></div></body></html>`)
+		}
+	}))
+	defer ts.Close()
+
+	lock := sync.Mutex{}
+	items := map[string]int{}
+	pages := map[string]int{}
+	s := NewSpider(
+		FollowLinks(
+			&LinkRule{
+				Allow:       []string{`/item/\w+$`},
+				Deny:        []string{`deny`},
+				RestrictCSS: []string{"#list"},
+				Handlers: []CtxHandlerFun{func(ctx *Context) {
+					lock.Lock()
+					defer lock.Unlock()
+					items[ctx.Req.URL.Path] += 1
+				}},
+			},
+			&LinkRule{
+				AllowGlob: []string{ts.URL + "/page/*"},
+				Follow:    true,
+				Handlers: []CtxHandlerFun{func(ctx *Context) {
+					lock.Lock()
+					defer lock.Unlock()
+					pages[ctx.Req.URL.Path] += 1
+				}},
+			},
+		),
+	)
+	s.AddTask(GetReq(ts.URL + "/"))
+	s.Run()
+
+	for _, p := range []string{"/item/1", "/item/2", "/item/5"} {
+		if items[p] != 1 {
+			t.Error("missing item", p, items)
+		}
+	}
+	for _, p := range []string{"/item/3", "/item/4", "/item/6", "/item/deny"} {
+		if _, ok := items[p]; ok {
+			t.Error("unexpected item", p)
+		}
+	}
+	if pages["/page/2"] != 1 || pages["/page/3"] != 1 {
+		t.Error("wrong pages", pages)
+	}
+}
+
+func TestFollowLinksTwice(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/":
+			_, _ = fmt.Fprint(w, `<html><body><a href="/a/1">a1</a><a href="/b/1">b1</a></body></html>`)
+		case "/a/1":
+			_, _ = fmt.Fprint(w, `<html><body><a href="/a/3">a3</a></body></html>`)
+		case "/b/1":
+			_, _ = fmt.Fprint(w, `<html><body><a href="/a/2">a2</a></body></html>`)
+		default:
+			_, _ = fmt.Fprint(w, `<html><body></body></html>`)
+		}
+	}))
+	defer ts.Close()
+
+	lock := sync.Mutex{}
+	got := map[string]int{}
+	record := func(ctx *Context) {
+		lock.Lock()
+		defer lock.Unlock()
+		got[ctx.Req.URL.Path] += 1
+	}
+	s := NewSpider(
+		FollowLinks(&LinkRule{Allow: []string{`/a/\d+$`}, Handlers: []CtxHandlerFun{record}}),
+		FollowLinks(&LinkRule{Allow: []string{`/b/\d+$`}, Follow: true, Handlers: []CtxHandlerFun{record}}),
+	)
+	s.AddTask(GetReq(ts.URL + "/"))
+	s.Run()
+
+	// the links in /b/1 are followed by the first FollowLinks,the rule index from the second one doesn't matter
+	for _, p := range []string{"/a/1", "/a/2", "/b/1"} {
+		if got[p] != 1 {
+			t.Error("missing page", p, got)
+		}
+	}
+	if _, ok := got["/a/3"]; ok {
+		t.Error("followed the links of a rule without Follow")
+	}
+}
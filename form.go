@@ -0,0 +1,196 @@
+package goribot
+
+import (
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// The enctypes of Form
+const (
+	FormURLEncoded = "application/x-www-form-urlencoded"
+	FormMultipart  = "multipart/form-data"
+)
+
+// ErrFormSubmitter is the Err of request from Form.SubmitWith when no submit button in the form matches the selector
+var ErrFormSubmitter = errors.New("submit button isn't found in form")
+
+// Form is a html <form> element parsed from response
+type Form struct {
+	// Action is the absolute url the form submits to,it's the url of page if the action attribute is empty
+	Action *url.URL
+	// Method is GET or POST
+	Method string
+	// Enctype is FormURLEncoded or FormMultipart
+	Enctype string
+	// Values are the default values of the inputs, selects and textareas,including the hidden fields like csrf token
+	Values url.Values
+	// Files are the file parts sent with a multipart form
+	Files []MultipartFile
+	// Selection is the <form> element
+	Selection *goquery.Selection
+}
+
+// Forms returns all the forms in the html response
+func (s *Response) Forms() []*Form {
+	if s.Dom == nil {
+		return nil
+	}
+	var res []*Form
+	base := s.baseURL()
+	s.Dom.Find("form").Each(func(i int, sel *goquery.Selection) {
+		res = append(res, parseForm(sel, base))
+	})
+	return res
+}
+
+// Form returns the first form matching the css selector like "form#login",or nil if not found
+func (s *Response) Form(selector string) *Form {
+	if s.Dom == nil {
+		return nil
+	}
+	sel := s.Dom.Find(selector).Filter("form").First()
+	if sel.Length() == 0 {
+		return nil
+	}
+	return parseForm(sel, s.baseURL())
+}
+
+func parseForm(sel *goquery.Selection, base *url.URL) *Form {
+	f := &Form{Method: "GET", Enctype: FormURLEncoded, Values: url.Values{}, Selection: sel}
+	if strings.EqualFold(strings.TrimSpace(sel.AttrOr("method", "")), "post") {
+		f.Method = "POST"
+	}
+	if strings.EqualFold(strings.TrimSpace(sel.AttrOr("enctype", "")), FormMultipart) {
+		f.Enctype = FormMultipart
+	}
+	f.Action = base
+	if a := strings.TrimSpace(sel.AttrOr("action", "")); a != "" {
+		if u, err := url.Parse(a); err == nil {
+			if base != nil {
+				u = base.ResolveReference(u)
+			}
+			f.Action = u
+		}
+	}
+
+	sel.Find("input, select, textarea").Each(func(i int, field *goquery.Selection) {
+		name, ok := field.Attr("name")
+		if !ok || name == "" {
+			return
+		}
+		if _, disabled := field.Attr("disabled"); disabled {
+			return
+		}
+		switch goquery.NodeName(field) {
+		case "input":
+			switch strings.ToLower(field.AttrOr("type", "text")) {
+			case "submit", "button", "image", "reset", "file":
+			case "checkbox", "radio":
+				if _, checked := field.Attr("checked"); checked {
+					f.Values.Add(name, field.AttrOr("value", "on"))
+				}
+			default:
+				f.Values.Add(name, field.AttrOr("value", ""))
+			}
+		case "select":
+			_, multiple := field.Attr("multiple")
+			options := field.Find("option")
+			selected := options.FilterFunction(func(i int, o *goquery.Selection) bool {
+				_, ok := o.Attr("selected")
+				return ok
+			})
+			if !multiple {
+				// the last selected option wins,or the first option is selected by default
+				if selected.Length() == 0 {
+					selected = options.First()
+				} else {
+					selected = selected.Last()
+				}
+			}
+			selected.Each(func(i int, o *goquery.Selection) {
+				f.Values.Add(name, o.AttrOr("value", strings.TrimSpace(o.Text())))
+			})
+		case "textarea":
+			f.Values.Add(name, field.Text())
+		}
+	})
+	return f
+}
+
+// Fill sets the values of the form fields,replacing the default values
+func (s *Form) Fill(values map[string]string) *Form {
+	for k, v := range values {
+		s.Values.Set(k, v)
+	}
+	return s
+}
+
+// AddFile adds a file to the form,it's sent only if the form is multipart
+func (s *Form) AddFile(field, fileName string, r io.Reader) *Form {
+	s.Files = append(s.Files, MultipartFile{Field: field, FileName: fileName, Reader: r})
+	return s
+}
+
+// Submit creates the request submitting the form
+func (s *Form) Submit() *Request {
+	if s.Method == "GET" {
+		u := *s.Action
+		u.RawQuery = s.Values.Encode()
+		u.Fragment = ""
+		return Get(u.String())
+	}
+	if s.Enctype == FormMultipart {
+		body, contentType, err := multipartBody(s.Values, s.Files)
+		req := PostRawReq(s.Action.String(), body)
+		if req.Err == nil {
+			req.Err = err
+		}
+		req.SetHeader("Content-Type", contentType)
+		return req
+	}
+	req := PostRawReq(s.Action.String(), []byte(s.Values.Encode()))
+	req.SetHeader("Content-Type", FormURLEncoded)
+	return req
+}
+
+// SubmitWith creates the request submitting the form by clicking the submit button matching the css selector,
+// e.g. "button[value=login]".The name and value of the button are sent with the form like a browser does,
+// and the form is submitted without them and ErrFormSubmitter as Err if no submit button in the form matches.
+func (s *Form) SubmitWith(selector string) *Request {
+	btn := s.Selection.Find(selector).FilterFunction(func(i int, sel *goquery.Selection) bool {
+		if _, disabled := sel.Attr("disabled"); disabled {
+			return false
+		}
+		t := strings.ToLower(sel.AttrOr("type", "submit"))
+		switch goquery.NodeName(sel) {
+		case "button":
+			return t == "submit"
+		case "input":
+			return t == "submit" || t == "image"
+		}
+		return false
+	}).First()
+	if btn.Length() == 0 {
+		req := s.Submit()
+		req.Err = ErrFormSubmitter
+		return req
+	}
+	f := *s
+	f.Values = url.Values{}
+	for k, v := range s.Values {
+		f.Values[k] = append([]string{}, v...)
+	}
+	if name := btn.AttrOr("name", ""); name != "" {
+		if strings.EqualFold(btn.AttrOr("type", ""), "image") {
+			// an image button sends the coordinates of click
+			f.Values.Add(name+".x", "0")
+			f.Values.Add(name+".y", "0")
+		} else {
+			f.Values.Add(name, btn.AttrOr("value", ""))
+		}
+	}
+	return f.Submit()
+}
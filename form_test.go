@@ -0,0 +1,86 @@
+package goribot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestForm(t *testing.T) {
+	var got []string
+	lock := sync.Mutex{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, `<html><body>
+<form id="search" action="/search#top"><input name="q" value="old"><input type="submit" name="go" value="Go"></form>
+<form id="login" method="post" action="login">
+	<input type="hidden" name="csrf" value="token">
+	<input name="user"><input type="password" name="pass">
+	<input type="checkbox" name="remember" checked><input type="checkbox" name="ad" value="1">
+	<input name="off" value="x" disabled>
+	<select name="lang"><option>en</option><option value="zh" selected>中文</option></select>
+	<textarea name="note">hi</textarea>
+	<button type="button" name="show" value="1">Show</button>
+	<button name="action" value="login">Login</button><button name="action" value="register">Register</button>
+</form>
+<form id="upload" method="POST" enctype="multipart/form-data" action="/upload"><input name="title" value="t"></form>
+</body></html>`)
+		case "/search":
+			got = append(got, r.URL.RawQuery)
+		case "/login":
+			_ = r.ParseForm()
+			got = append(got, r.PostForm.Encode())
+		case "/upload":
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+			}
+			got = append(got, r.PostForm.Encode())
+		}
+	}))
+	defer ts.Close()
+
+	s := NewSpider()
+	s.AddTask(Get(ts.URL), func(ctx *Context) {
+		if len(ctx.Resp.Forms()) != 3 {
+			t.Error("wrong forms number")
+		}
+		login := ctx.Resp.Form("#login")
+		if login.Method != "POST" || login.Action.String() != ts.URL+"/login" || login.Values.Get("csrf") != "token" {
+			t.Error("wrong login form", login.Method, login.Action, login.Values)
+		}
+		ctx.AddTask(ctx.Resp.Form("#search").Fill(map[string]string{"q": "goribot"}).SubmitWith("input[name=go]"))
+		ctx.AddTask(login.Fill(map[string]string{"user": "u", "pass": "p"}).Submit())
+		ctx.AddTask(login.SubmitWith("button[value=register]"))
+		if login.Values.Get("action") != "" {
+			t.Error("SubmitWith changes the values of form")
+		}
+		if login.SubmitWith("button[name=show]").Err != ErrFormSubmitter {
+			t.Error("a button not for submitting is used")
+		}
+		ctx.AddTask(ctx.Resp.Form("form#upload").Submit())
+		if ctx.Resp.Form("#nothing") != nil {
+			t.Error("got an unexisting form")
+		}
+	})
+	s.Run()
+	want := map[string]bool{
+		"go=Go&q=goribot": true,
+		"csrf=token&lang=zh&note=hi&pass=p&remember=on&user=u":                 true,
+		"action=register&csrf=token&lang=zh&note=hi&pass=p&remember=on&user=u": true,
+		"title=t": true,
+	}
+	if len(got) != 4 {
+		t.Fatal("wrong submitted forms", got)
+	}
+	for _, g := range got {
+		if !want[g] {
+			t.Error("wrong submitted form", g)
+		}
+	}
+}
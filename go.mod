@@ -4,8 +4,13 @@ go 1.14
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.0
+	github.com/andybalholm/brotli v1.0.2
+	github.com/antchfx/htmlquery v1.2.3
+	github.com/antchfx/xmlquery v1.2.4
+	github.com/antchfx/xpath v1.1.6
 	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/gobwas/glob v0.2.3
+	github.com/klauspost/compress v1.10.5
 	github.com/onsi/ginkgo v1.12.0 // indirect
 	github.com/onsi/gomega v1.9.0 // indirect
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
@@ -13,5 +18,6 @@ require (
 	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca
 	github.com/slyrz/robots v0.0.0-20150806122829-7ebb2b6fc59f
 	github.com/tidwall/gjson v1.6.0
-	golang.org/x/net v0.0.0-20191003171128-d98b1b443823
+	golang.org/x/net v0.0.0-20200421231249-e086a090c8fd
+	golang.org/x/text v0.3.0
 )
@@ -4,12 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
 	"github.com/op/go-logging"
 	"github.com/panjf2000/ants/v2"
 	"github.com/tidwall/gjson"
 	"os"
+	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -46,10 +52,13 @@ type Spider struct {
 	onReqHandlers                     []func(ctx *Context, req *Request) *Request
 	onAddHandlers                     []func(ctx *Context, req *Task) *Task
 	onRespHandlers                    []CtxHandlerFun
+	onRedirectHandlers                []func(ctx *Context, hop *RedirectHop) bool
 	onItemHandlers                    []func(i interface{}) interface{}
 	onErrorHandlers                   []func(ctx *Context, err error)
 	newTask                           chan struct{}
-	isWaiting                         bool
+	isWaiting                         int32 // accessed atomically
+	stop                              chan struct{}
+	stopOnce                          sync.Once
 }
 
 func NewSpider(exts ...func(s *Spider)) *Spider {
@@ -68,7 +77,7 @@ func NewSpider(exts ...func(s *Spider)) *Spider {
 		itemPool:   ip,
 		AutoStop:   true,
 		newTask:    make(chan struct{}),
-		isWaiting:  false,
+		stop:       make(chan struct{}),
 	}
 	s.Use(exts...)
 	return s
@@ -91,13 +100,30 @@ func (s *Spider) AddTask(request *Request, handlers ...CtxHandlerFun) {
 	if t != nil {
 		s.Scheduler.AddTask(t)
 	}
-	if s.AutoStop == false && s.isWaiting {
+	if s.AutoStop == false && atomic.LoadInt32(&s.isWaiting) == 1 {
 		go func() {
 			s.newTask <- struct{}{}
 		}()
 	}
 }
 
+// stopRunning makes Run return when no task is running and the queue is empty,as if AutoStop is set.
+// It's safe to call while the spider is running.
+func (s *Spider) stopRunning() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *Spider) isStopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *Spider) Use(fn ...func(s *Spider)) {
 	for _, f := range fn {
 		f(s)
@@ -108,10 +134,10 @@ func (s *Spider) Run() {
 	defer s.taskPool.Release()
 	defer s.itemPool.Release()
 	s.handleOnStart()
-	taskRunning := true
+	var taskDone int32
 	if s.itemPool.Cap() > 0 {
 		go func() {
-			for taskRunning {
+			for atomic.LoadInt32(&taskDone) == 0 {
 				if s.itemPool.Free() > 0 {
 					if i := s.Scheduler.GetItem(); i != nil {
 						err := s.itemPool.Submit(func() {
@@ -132,9 +158,12 @@ func (s *Spider) Run() {
 
 	for {
 		if s.taskPool.Free() > 0 {
-			s.isWaiting = false
+			atomic.StoreInt32(&s.isWaiting, 0)
 			if t := s.Scheduler.GetTask(); t != nil {
 				err := s.taskPool.Submit(func() {
+					if a, ok := s.Scheduler.(AckScheduler); ok {
+						defer a.AckTask(t)
+					}
 					ctx := &Context{
 						Req:      t.Request,
 						Resp:     nil,
@@ -160,6 +189,12 @@ func (s *Spider) Run() {
 								s.handleOnError(ctx, err)
 							}
 						}()
+						if ctx.noFollow {
+							ctx.tasks = nil
+						}
+						if ctx.noIndex {
+							ctx.items = nil
+						}
 						for _, i := range ctx.tasks {
 							if !i.Request.URL.IsAbs() {
 								i.Request.URL = ctx.Resp.Request.URL.ResolveReference(i.Request.URL)
@@ -170,7 +205,7 @@ func (s *Spider) Run() {
 							i := s.handleOnAdd(ctx, i)
 							if i != nil {
 								s.Scheduler.AddTask(i)
-								if s.AutoStop == false && s.isWaiting {
+								if s.AutoStop == false && atomic.LoadInt32(&s.isWaiting) == 1 {
 									go func() {
 										s.newTask <- struct{}{}
 									}()
@@ -178,7 +213,13 @@ func (s *Spider) Run() {
 							}
 						}
 						for _, i := range ctx.items {
-							s.Scheduler.AddItem(i)
+							if is, ok := s.Scheduler.(ItemErrScheduler); ok {
+								if err := is.TryAddItem(i); err != nil {
+									s.handleOnError(ctx, err)
+								}
+							} else {
+								s.Scheduler.AddItem(i)
+							}
 						}
 					}()
 					defer func() { // 主回调函数异常处理
@@ -196,12 +237,21 @@ func (s *Spider) Run() {
 							s.handleOnError(ctx, err)
 						}
 					}()
+					if t.Request.Err != nil {
+						s.handleOnError(ctx, t.Request.Err)
+						return
+					}
 					req := s.handleOnReq(ctx, t.Request)
-					if req.Err != nil {
+					if req != nil && req.Err != nil {
 						s.handleOnError(ctx, req.Err)
 						return
 					}
 					if req != nil {
+						if len(s.onRedirectHandlers) > 0 {
+							req.onRedirect = func(hop *RedirectHop) bool {
+								return s.handleOnRedirect(ctx, hop)
+							}
+						}
 						resp, err := s.Downloader.Do(req)
 						ctx.Resp = resp
 						if err == nil {
@@ -225,15 +275,17 @@ func (s *Spider) Run() {
 					panic(ErrRunFinishedSpider)
 				}
 			} else if s.taskPool.Running() == 0 {
-				if s.AutoStop {
+				if s.AutoStop || s.isStopping() {
 					break
 				} else {
-					s.isWaiting = true
+					atomic.StoreInt32(&s.isWaiting, 1)
 					select {
 					case _ = <-time.After(5 * time.Second):
 						break
 					case _ = <-s.newTask:
 						break
+					case _ = <-s.stop:
+						break
 					}
 				}
 			}
@@ -243,7 +295,7 @@ func (s *Spider) Run() {
 		}
 		runtime.Gosched()
 	}
-	taskRunning = false
+	atomic.StoreInt32(&taskDone, 1)
 	s.handleOnFinish()
 }
 
@@ -308,6 +360,36 @@ func (s *Spider) OnHTML(selector string, fn func(ctx *Context, sel *goquery.Sele
 		}
 	})
 }
+func (s *Spider) OnXPath(expr string, fn func(ctx *Context, sel *goquery.Selection)) {
+	exp := xpath.MustCompile(expr)
+	s.onRespHandlers = append(s.onRespHandlers, func(ctx *Context) {
+		if ctx.Resp.Dom != nil {
+			for _, n := range htmlquery.QuerySelectorAll(ctx.Resp.Dom.Nodes[0], exp) {
+				fn(ctx, xpathSelection(ctx.Resp.Dom, n))
+			}
+		}
+	})
+}
+func (s *Spider) OnXML(expr string, fn func(ctx *Context, n *xmlquery.Node)) {
+	exp := xpath.MustCompile(expr)
+	s.onRespHandlers = append(s.onRespHandlers, func(ctx *Context) {
+		if ctx.Resp.XMLDom != nil {
+			for _, n := range xmlquery.QuerySelectorAll(ctx.Resp.XMLDom, exp) {
+				fn(ctx, n)
+			}
+		}
+	})
+}
+func (s *Spider) OnRegexp(pattern string, fn func(ctx *Context, match []string)) {
+	re := regexp.MustCompile(pattern)
+	s.onRespHandlers = append(s.onRespHandlers, func(ctx *Context) {
+		if ctx.Resp.Text != "" {
+			for _, m := range re.FindAllStringSubmatch(ctx.Resp.Text, -1) {
+				fn(ctx, m)
+			}
+		}
+	})
+}
 func (s *Spider) OnJSON(q string, fn func(ctx *Context, j gjson.Result)) {
 	s.onRespHandlers = append(s.onRespHandlers, func(ctx *Context) {
 		if ctx.Resp.IsJSON() {
@@ -328,6 +410,21 @@ func (s *Spider) handleOnResp(ctx *Context) {
 	}
 }
 
+/*************************************************************************************/
+// OnRedirect adds a hook called before following a redirect,the redirect is stopped if any hook returns false
+// and the redirect response is used.It works only with BaseDownloader.
+func (s *Spider) OnRedirect(fn func(ctx *Context, hop *RedirectHop) bool) {
+	s.onRedirectHandlers = append(s.onRedirectHandlers, fn)
+}
+func (s *Spider) handleOnRedirect(ctx *Context, hop *RedirectHop) bool {
+	for _, fn := range s.onRedirectHandlers {
+		if !fn(ctx, hop) {
+			return false
+		}
+	}
+	return true
+}
+
 /*************************************************************************************/
 func (s *Spider) OnItem(fn func(i interface{}) interface{}) {
 	s.onItemHandlers = append(s.onItemHandlers, fn)
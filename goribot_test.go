@@ -2,6 +2,11 @@ package goribot
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -85,3 +90,42 @@ func TestAbort(t *testing.T) {
 		t.Error("didn't get response")
 	}
 }
+
+type ackScheduler struct {
+	*BaseScheduler
+	lock  sync.Mutex
+	acked []string
+}
+
+func (s *ackScheduler) AckTask(t *Task) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.acked = append(s.acked, t.Request.URL.Path)
+}
+
+func TestAckScheduler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	as := &ackScheduler{BaseScheduler: NewBaseScheduler(false)}
+	s := NewSpider()
+	s.Scheduler = as
+	// the tasks acked while the handler of /a is running,only /c could be done by then
+	var ackedInA []string
+	s.AddTask(Get(ts.URL+"/a"), func(ctx *Context) {
+		as.lock.Lock()
+		ackedInA = append([]string{}, as.acked...)
+		as.lock.Unlock()
+		ctx.AddTask(Get(ts.URL + "/b"))
+	})
+	s.AddTask(Get(ts.URL+"/c"), func(ctx *Context) {
+		panic("handler error")
+	})
+	s.Run()
+	if j := strings.Join(ackedInA, ","); j != "" && j != "/c" {
+		t.Error("acked before handlers finished", ackedInA)
+	}
+	sort.Strings(as.acked)
+	if strings.Join(as.acked, ",") != "/a,/b,/c" {
+		t.Error("wrong acked tasks", as.acked)
+	}
+}
@@ -1,6 +1,7 @@
 package goribot
 
 import (
+	"context"
 	"github.com/gobwas/glob"
 	"math/rand"
 	"net/url"
@@ -17,137 +18,438 @@ const (
 	NotSet LimitRuleAllow = iota
 	Allow
 	Disallow
+	// NoFilter makes the rule limit the requests only by Parallelism, Rate and Delay,
+	// it's ignored when the new tasks are filtered,so it doesn't allow a site under WhiteList.
+	NoFilter
 )
 
+// LimitRuleMatch is the part of url matched by LimitRule
+type LimitRuleMatch uint8
+
+const (
+	// MatchHost matches the host of url,including the port
+	MatchHost LimitRuleMatch = iota
+	// MatchPath matches the path of url
+	MatchPath
+	// MatchURL matches the whole url
+	MatchURL
+)
+
+// LimitRule limits the requests whose host,path or url (see MatchOn) matches Regexp or Glob.
+// All the constraints of a rule combine,and all the rules matching a request apply.
+// Rate is the max number of requests per second by token bucket,could be fractional like 0.2,
+// and Burst is the number of requests could be sent at once,1 by default.
+// If PerHost is set, Parallelism, Rate, Delay and MaxReq are counted for each host separately,
+// e.g. a rule with Glob "*.example.com" limits every subdomain by itself.
 type LimitRule struct {
-	Regexp, Glob       string
-	Allow              LimitRuleAllow
-	Parallelism        int64
-	workingParallelism int64
-	Rate               int64
-	rateLeft           int64
-	Delay              time.Duration
-	RandomDelay        time.Duration
-	MaxReq             int64
-	reqLeft            int64
-	MaxDepth           int64
-	lastReqTime        time.Time
-	compiledRegexp     *regexp.Regexp
-	compiledGlob       glob.Glob
-	delayLock          sync.Mutex
+	Regexp, Glob string
+	MatchOn      LimitRuleMatch
+	PerHost      bool
+	Allow        LimitRuleAllow
+	Parallelism  int64
+	Rate         float64
+	Burst        int64
+	Delay        time.Duration
+	RandomDelay  time.Duration
+	MaxReq       int64
+	MaxDepth     int64
+	statesLock   sync.Mutex
+	conf         *limitConfig
+	states       map[string]*limitState
 }
 
-func (s *LimitRule) Match(u *url.URL) bool {
-	match := false
-	if s.compiledGlob != nil {
-		match = s.compiledGlob.Match(strings.ToLower(u.Host))
+// limitConfig is a snapshot of the settings of LimitRule made by compile.
+// The requests read it instead of the exported fields,so updating the rule doesn't race with them.
+type limitConfig struct {
+	regexp             *regexp.Regexp
+	glob               glob.Glob
+	matchOn            LimitRuleMatch
+	perHost            bool
+	allow              LimitRuleAllow
+	parallelism        int64
+	rate               float64
+	burst              int64
+	delay, randomDelay time.Duration
+	maxReq, maxDepth   int64
+}
+
+// limitState is the state of a LimitRule,or of a host in the rule if PerHost is set.
+// It's kept when the rule is updated,so the running requests count against the new settings.
+type limitState struct {
+	lock    sync.Mutex
+	conf    *limitConfig
+	running int64
+	// wake is closed and replaced when a request finishes or the settings change
+	wake        chan struct{}
+	bucket      *tokenBucket
+	delayLock   sync.Mutex
+	lastReqTime time.Time
+	reqUsed     int64
+}
+
+// LimitUsage is the current usage of a LimitRule,or of a host in the rule if PerHost is set
+type LimitUsage struct {
+	// Running is the number of running requests
+	Running int64
+	// Requests is the number of accepted new tasks,which is limited by MaxReq
+	Requests int64
+}
+
+// tokenBucket limits the rate of events,the tokens are computed when taken so no goroutine is needed
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int64) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it,the tokens could be negative as reserved
+func (s *tokenBucket) reserve() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.rate
+	if s.tokens > s.burst {
+		s.tokens = s.burst
+	}
+	s.last = now
+	s.tokens -= 1
+	if s.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-s.tokens / s.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token
+func (s *tokenBucket) cancel() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens += 1
+}
+
+// wait blocks until a token is available or ctx is done
+func (s *tokenBucket) wait(ctx context.Context) error {
+	d := s.reserve()
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// compile compiles the pattern and takes a snapshot of the settings,which applies to the existing states as well.
+// The running requests and the usage of MaxReq are kept.It's called with the lock of LimiterController held.
+func (s *LimitRule) compile() {
+	if s.Allow == NotSet {
+		s.Allow = Allow
+	}
+	c := &limitConfig{
+		matchOn:     s.MatchOn,
+		perHost:     s.PerHost,
+		allow:       s.Allow,
+		parallelism: s.Parallelism,
+		rate:        s.Rate,
+		burst:       s.Burst,
+		delay:       s.Delay,
+		randomDelay: s.RandomDelay,
+		maxReq:      s.MaxReq,
+		maxDepth:    s.MaxDepth,
+	}
+	if s.Glob != "" {
+		c.glob = glob.MustCompile(s.Glob)
 	} else {
-		match = s.compiledRegexp.MatchString(strings.ToLower(u.Host))
+		c.regexp = regexp.MustCompile(s.Regexp)
+	}
+	s.statesLock.Lock()
+	defer s.statesLock.Unlock()
+	perHostChanged := s.conf != nil && s.conf.perHost != c.perHost
+	s.conf = c
+	if perHostChanged || s.states == nil {
+		// the keys of states change,so they can't be kept
+		s.states = map[string]*limitState{}
+	}
+	for _, st := range s.states {
+		st.update(c)
 	}
-	return match
 }
 
-func Limiter(WhiteList bool, rules ...*LimitRule) func(s *Spider) {
-	for k, r := range rules {
-		if r.Allow == NotSet {
-			rules[k].Allow = Allow
+// config returns the snapshot of settings,nil if the rule isn't compiled
+func (s *LimitRule) config() *limitConfig {
+	s.statesLock.Lock()
+	defer s.statesLock.Unlock()
+	return s.conf
+}
+
+// state returns the limitState of url
+func (s *LimitRule) state(u *url.URL) *limitState {
+	s.statesLock.Lock()
+	defer s.statesLock.Unlock()
+	key := ""
+	if s.conf.perHost {
+		key = strings.ToLower(u.Host)
+	}
+	st, ok := s.states[key]
+	if !ok {
+		st = &limitState{wake: make(chan struct{})}
+		st.update(s.conf)
+		s.states[key] = st
+	}
+	return st
+}
+
+// Match returns whether the rule matches u,a rule not added to Limiter matches nothing
+func (s *LimitRule) Match(u *url.URL) bool {
+	c := s.config()
+	if c == nil {
+		return false
+	}
+	var target string
+	switch c.matchOn {
+	case MatchPath:
+		target = u.EscapedPath()
+	case MatchURL:
+		target = u.String()
+	default:
+		target = strings.ToLower(u.Host)
+	}
+	if c.glob != nil {
+		return c.glob.Match(target)
+	}
+	return c.regexp.MatchString(target)
+}
+
+// update applies the settings to the state and wakes up the waiting requests,
+// the token bucket is kept unless Rate or Burst changes
+func (s *limitState) update(c *limitConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if c.rate <= 0 {
+		s.bucket = nil
+	} else if s.bucket == nil || s.conf.rate != c.rate || s.conf.burst != c.burst {
+		s.bucket = newTokenBucket(c.rate, c.burst)
+	}
+	s.conf = c
+	s.notify()
+}
+
+func (s *limitState) notify() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// acquire waits until the request is allowed by Parallelism, Rate and Delay,release should be called after the request.
+func (s *limitState) acquire(req *Request) (release func(), err error) {
+	ctx := req.Request.Context()
+	s.lock.Lock()
+	for s.conf.parallelism > 0 && s.running >= s.conf.parallelism {
+		wake := s.wake
+		s.lock.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		rules[k].rateLeft = r.Rate
-		rules[k].reqLeft = r.MaxReq
-		rules[k].delayLock = sync.Mutex{}
-		if rules[k].Glob != "" {
-			rules[k].compiledGlob = glob.MustCompile(rules[k].Glob)
-		} else {
-			rules[k].compiledRegexp = regexp.MustCompile(rules[k].Regexp)
+		s.lock.Lock()
+	}
+	s.running += 1
+	c, bucket := s.conf, s.bucket
+	s.lock.Unlock()
+	release = func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.running -= 1
+		s.notify()
+	}
+
+	if bucket != nil {
+		if err = bucket.wait(ctx); err != nil {
+			release()
+			return nil, err
 		}
 	}
-	rateCtl := true
-	go func() {
-		for rateCtl {
-			time.Sleep(1 * time.Second)
-			for k, _ := range rules {
-				atomic.StoreInt64(&rules[k].rateLeft, rules[k].Rate)
-			}
+	if c.delay > 0 || c.randomDelay > 0 {
+		s.delayLock.Lock()
+		if since := time.Since(s.lastReqTime); since < c.delay {
+			time.Sleep(c.delay - since)
+		}
+		if c.randomDelay > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(c.randomDelay))))
+		}
+		s.lastReqTime = time.Now()
+		s.delayLock.Unlock()
+	}
+	return release, nil
+}
+
+// LimiterController changes the rules of Limiter while the spider is running,see NewLimiter
+type LimiterController struct {
+	lock      sync.RWMutex
+	whiteList bool
+	rules     []*LimitRule
+}
+
+// AddRule adds a rule after the existing ones
+func (s *LimiterController) AddRule(r *LimitRule) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r.compile()
+	s.rules = append(s.rules, r)
+}
+
+// RemoveRule removes the rule,returns false if it's not found
+func (s *LimiterController) RemoveRule(r *LimitRule) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, i := range s.rules {
+		if i == r {
+			s.rules = append(s.rules[:k:k], s.rules[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateRule changes the rule by fn,e.g. setting a larger Delay.
+// The new settings apply to the requests sent after,and the running requests and the usage of MaxReq are kept,
+// e.g. the running requests count against a new Parallelism.
+func (s *LimiterController) UpdateRule(r *LimitRule, fn func(r *LimitRule)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn(r)
+	r.compile()
+}
+
+// Rules returns the current rules
+func (s *LimiterController) Rules() []*LimitRule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*LimitRule{}, s.rules...)
+}
+
+// ResetMaxReq resets the usage of MaxReq of the rules,or all rules if none is given
+func (s *LimiterController) ResetMaxReq(rules ...*LimitRule) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(rules) == 0 {
+		rules = s.rules
+	}
+	for _, r := range rules {
+		r.statesLock.Lock()
+		for _, st := range r.states {
+			atomic.StoreInt64(&st.reqUsed, 0)
 		}
-	}()
+		r.statesLock.Unlock()
+	}
+}
+
+// Usage returns the current usage of the rule,keyed by host if PerHost is set,otherwise by ""
+func (s *LimiterController) Usage(r *LimitRule) map[string]LimitUsage {
+	r.statesLock.Lock()
+	defer r.statesLock.Unlock()
+	res := map[string]LimitUsage{}
+	for k, st := range r.states {
+		st.lock.Lock()
+		res[k] = LimitUsage{Running: st.running, Requests: atomic.LoadInt64(&st.reqUsed)}
+		st.lock.Unlock()
+	}
+	return res
+}
+
+// matchedRules returns the rules matching u
+func (s *LimiterController) matchedRules(u *url.URL) []*LimitRule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var res []*LimitRule
+	for _, r := range s.rules {
+		if r.Match(u) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Limiter is an extension limits the requests by rules, see NewLimiter
+func Limiter(WhiteList bool, rules ...*LimitRule) func(s *Spider) {
+	ext, _ := NewLimiter(WhiteList, rules...)
+	return ext
+}
+
+// NewLimiter creates an extension limits the requests by rules,
+// and a LimiterController to change the rules while the spider is running.
+// A new task is dropped if any matching rule disallows it or exceeds its MaxDepth or MaxReq,
+// or no rule matches it when WhiteList is true,the rules with NoFilter are ignored here.
+func NewLimiter(WhiteList bool, rules ...*LimitRule) (func(s *Spider), *LimiterController) {
+	c := &LimiterController{whiteList: WhiteList}
+	for _, r := range rules {
+		c.AddRule(r)
+	}
 	return func(s *Spider) {
 		s.Downloader.AddMiddleware(func(req *Request, next func(req *Request) (resp *Response, err error)) (resp *Response, err error) {
-			for k, r := range rules {
-				if r.Match(req.URL) {
-					if r.Delay > 0 || r.RandomDelay > 0 {
-						rules[k].delayLock.Lock()
-						since := time.Since(r.lastReqTime)
-						if since < r.Delay {
-							time.Sleep(r.Delay - since)
-						}
-						if r.RandomDelay > 0 {
-							ra := rand.New(rand.NewSource(time.Now().Unix()))
-							time.Sleep(time.Duration(ra.Int63n(int64(r.RandomDelay))))
-						}
-						rules[k].lastReqTime = time.Now()
-						rules[k].delayLock.Unlock()
-						return next(req)
-					} else if r.Rate > 0 {
-						wait := true
-						for wait {
-							if atomic.LoadInt64(&rules[k].rateLeft) > 0 {
-								atomic.AddInt64(&rules[k].rateLeft, -1)
-								wait = false
-							} else {
-								time.Sleep(500 * time.Microsecond)
-							}
-						}
-						return next(req)
-					} else if r.Parallelism > 0 {
-						wait := true
-						for wait {
-							if atomic.LoadInt64(&rules[k].workingParallelism) < r.Parallelism {
-								atomic.AddInt64(&rules[k].workingParallelism, 1)
-								wait = false
-							} else {
-								time.Sleep(500 * time.Microsecond)
-							}
-						}
-						resp, err := next(req)
-						atomic.AddInt64(&rules[k].workingParallelism, -1)
-						return resp, err
-					} else {
-						return next(req)
-					}
+			var releases []func()
+			defer func() {
+				for i := len(releases) - 1; i >= 0; i-- {
+					releases[i]()
+				}
+			}()
+			for _, r := range c.matchedRules(req.URL) {
+				release, err := r.state(req.URL).acquire(req)
+				if err != nil {
+					return nil, err
 				}
+				releases = append(releases, release)
 			}
 			return next(req)
 		})
 		s.OnAdd(func(ctx *Context, t *Task) *Task {
-			for k, r := range rules {
+			c.lock.RLock()
+			defer c.lock.RUnlock()
+			var matched []*LimitRule
+			for _, r := range c.rules {
 				if r.Match(t.Request.URL) {
-					if r.Allow == Disallow {
-						return nil
+					conf := r.config()
+					if conf.allow == NoFilter {
+						continue
 					}
-					if r.MaxDepth > 0 {
-						//fmt.Println(t.Request.Depth)
-						if int64(t.Request.Depth) > r.MaxDepth {
-							return nil
-						}
-					}
-					if r.MaxReq > 0 {
-						if atomic.LoadInt64(&rules[k].reqLeft) > 0 {
-							atomic.AddInt64(&rules[k].reqLeft, -1)
-						} else {
-							return nil
-						}
+					if conf.allow == Disallow || (conf.maxDepth > 0 && int64(t.Request.Depth) > conf.maxDepth) {
+						return nil
 					}
-					return t
+					matched = append(matched, r)
 				}
 			}
-			if WhiteList {
-				return nil
-			} else {
+			if len(matched) == 0 {
+				if c.whiteList {
+					return nil
+				}
 				return t
 			}
+			var taken []*limitState
+			for _, r := range matched {
+				st, maxReq := r.state(t.Request.URL), r.config().maxReq
+				if used := atomic.AddInt64(&st.reqUsed, 1); maxReq > 0 && used > maxReq {
+					atomic.AddInt64(&st.reqUsed, -1)
+					for _, i := range taken {
+						atomic.AddInt64(&i.reqUsed, -1)
+					}
+					return nil
+				}
+				taken = append(taken, st)
+			}
+			return t
 		})
-		s.OnFinish(func(s *Spider) {
-			rateCtl = false
-		})
-	}
+	}, c
 }
@@ -1,9 +1,14 @@
 package goribot
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -162,3 +167,174 @@ func TestMaxDepth(t *testing.T) {
 		t.Error("wrong req got", got)
 	}
 }
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(20, 2)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := b.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && time.Since(start) > 20*time.Millisecond {
+			t.Error("burst isn't allowed")
+		}
+	}
+	if d := time.Since(start); d < 190*time.Millisecond || d > 400*time.Millisecond {
+		t.Error("wrong rate", d)
+	}
+
+	b = newTokenBucket(0.2, 1)
+	_ = b.wait(context.Background())
+	if d := b.reserve(); d < 4900*time.Millisecond {
+		t.Error("wrong fractional rate", d)
+	}
+	b.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx); err != context.DeadlineExceeded {
+		t.Error("wait isn't canceled", err)
+	}
+}
+
+func TestLimiterCombine(t *testing.T) {
+	var running, maxRunning int32
+	var lock sync.Mutex
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		lock.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		got = append(got, r.Host+r.URL.Path)
+		lock.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}))
+	defer ts.Close()
+	run := func(urls []string, rules ...*LimitRule) time.Duration {
+		atomic.StoreInt32(&maxRunning, 0)
+		got = nil
+		start := time.Now()
+		s := NewSpider(Limiter(false, rules...))
+		s.SetTaskPoolSize(4)
+		for _, u := range urls {
+			s.AddTask(Get(u))
+		}
+		s.Run()
+		return time.Since(start)
+	}
+
+	d := run([]string{ts.URL + "/1", ts.URL + "/2", ts.URL + "/3"}, &LimitRule{Glob: "*", Parallelism: 2, Delay: 150 * time.Millisecond})
+	if d < 300*time.Millisecond || maxRunning != 1 {
+		t.Error("constraints don't combine", d, maxRunning)
+	}
+
+	local := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	run([]string{ts.URL + "/1", local + "/1", ts.URL + "/2", local + "/2"}, &LimitRule{Glob: "*", PerHost: true, Parallelism: 1})
+	if maxRunning != 2 {
+		t.Error("wrong per host parallelism", maxRunning)
+	}
+
+	run([]string{ts.URL + "/a1", ts.URL + "/a2", ts.URL + "/private/b", ts.URL + "/b1", ts.URL + "/b2", ts.URL + "/b3"},
+		&LimitRule{Glob: "*", MaxReq: 3},
+		&LimitRule{Glob: "/a*", MatchOn: MatchPath, MaxReq: 1},
+		&LimitRule{Regexp: `^http://[^/]+/private/`, MatchOn: MatchURL, Allow: Disallow},
+	)
+	sort.Strings(got)
+	host := strings.TrimPrefix(ts.URL, "http://")
+	if strings.Join(got, ",") != strings.Join([]string{host + "/a1", host + "/b1", host + "/b2"}, ",") {
+		t.Error("rules don't stack", got)
+	}
+}
+
+func TestLimiterController(t *testing.T) {
+	var got int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&got, 1)
+	}))
+	defer ts.Close()
+	rule := &LimitRule{Glob: "*", MaxReq: 2}
+	ext, c := NewLimiter(false, rule)
+	s := NewSpider(ext)
+	for i := 0; i < 3; i++ {
+		s.AddTask(Get(ts.URL))
+	}
+	s.Run()
+	if got != 2 || c.Usage(rule)[""].Requests != 2 {
+		t.Error("wrong MaxReq", got, c.Usage(rule))
+	}
+
+	c.ResetMaxReq()
+	c.UpdateRule(rule, func(r *LimitRule) {
+		r.MaxReq = 3
+		r.Delay = 100 * time.Millisecond
+	})
+	start := time.Now()
+	s = NewSpider(ext)
+	for i := 0; i < 4; i++ {
+		s.AddTask(Get(ts.URL))
+	}
+	s.Run()
+	if got != 5 || time.Since(start) < 200*time.Millisecond {
+		t.Error("rule isn't updated", got, time.Since(start))
+	}
+
+	deny := &LimitRule{Glob: "*", Allow: Disallow}
+	c.AddRule(deny)
+	s = NewSpider(ext)
+	s.AddTask(Get(ts.URL))
+	s.Run()
+	if got != 5 || len(c.Rules()) != 2 {
+		t.Error("rule isn't added", got)
+	}
+	if !c.RemoveRule(deny) || c.RemoveRule(deny) {
+		t.Error("wrong RemoveRule")
+	}
+	c.ResetMaxReq(rule)
+	s = NewSpider(ext)
+	s.AddTask(Get(ts.URL))
+	s.Run()
+	if got != 6 {
+		t.Error("rule isn't removed", got)
+	}
+}
+
+func TestLimiterUpdateRunning(t *testing.T) {
+	var cur, updated, over int32
+	started, release := make(chan struct{}), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer atomic.AddInt32(&cur, -1)
+		if n := atomic.AddInt32(&cur, 1); atomic.LoadInt32(&updated) == 1 && n > 1 {
+			atomic.AddInt32(&over, 1)
+		}
+		if atomic.LoadInt32(&updated) == 0 {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	defer ts.Close()
+	rule := &LimitRule{Glob: "*", Parallelism: 2}
+	ext, c := NewLimiter(false, rule)
+	go func() {
+		<-started
+		<-started
+		c.UpdateRule(rule, func(r *LimitRule) {
+			r.Parallelism = 1
+			r.Delay = 10 * time.Millisecond
+		})
+		if u := c.Usage(rule)[""]; u.Running != 2 {
+			t.Error("running requests are dropped", u)
+		}
+		atomic.StoreInt32(&updated, 1)
+		close(release)
+	}()
+	s := NewSpider(ext)
+	for i := 0; i < 5; i++ {
+		s.AddTask(Get(ts.URL))
+	}
+	s.Run()
+	if over != 0 {
+		t.Error("running requests don't count against the new Parallelism", over)
+	}
+}
@@ -1,41 +1,62 @@
 package goribot
 
 import (
-	"bytes"
-	"encoding/gob"
+	"crypto/md5"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"github.com/panjf2000/ants/v2"
+	"math/rand"
+	"net/http"
+	"os"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const ItemsSuffix = "_items"
 const TasksSuffix = "_tasks"
 const DeduplicateSuffix = "_deduplicate"
+const BloomSuffix = "_bloom"
 
-type item struct {
-	Data interface{}
-}
+// ProcessingSuffix is the suffix of the processing list of a worker in reliable mode,followed by "_" and the worker id
+const ProcessingSuffix = "_processing"
+
+// WorkersSuffix is the suffix of the set of workers,with the last heartbeat time of each worker
+const WorkersSuffix = "_workers"
 
+// Manager sends seed tasks to the workers and handles the items from them in distributed mode
 type Manager struct {
-	itemPool       *ants.Pool
-	redis          *redis.Client
-	sName          string
-	onItemHandlers []func(i interface{}) interface{}
+	itemPool        *ants.Pool
+	backend         Backend
+	redis           *redis.Client
+	sName           string
+	onItemHandlers  []func(i interface{}) interface{}
+	onErrorHandlers []func(err error)
+	stop            chan struct{}
+	stopOnce        sync.Once
 }
 
+// NewManager creates a Manager based on redis
 func NewManager(redis *redis.Client, sName string) *Manager {
+	m := NewBackendManager(NewRedisBackend(redis), sName)
+	m.redis = redis
+	return m
+}
+
+// NewBackendManager creates a Manager based on the Backend
+func NewBackendManager(b Backend, sName string) *Manager {
 	ip, err := ants.NewPool(runtime.NumCPU())
 	if err != nil {
 		panic(err)
 	}
 	return &Manager{
 		itemPool:       ip,
-		redis:          redis,
+		backend:        b,
 		sName:          sName,
 		onItemHandlers: []func(i interface{}) interface{}{},
+		stop:           make(chan struct{}),
 	}
 }
 
@@ -43,6 +64,18 @@ func (s *Manager) OnItem(fn func(i interface{}) interface{}) {
 	s.onItemHandlers = append(s.onItemHandlers, fn)
 }
 
+// OnError adds a handler of the errors occurred in manager,e.g. a DecodeErr of item
+func (s *Manager) OnError(fn func(err error)) {
+	s.onErrorHandlers = append(s.onErrorHandlers, fn)
+}
+
+func (s *Manager) handleOnError(err error) {
+	Log.Error(err)
+	for _, fn := range s.onErrorHandlers {
+		fn(err)
+	}
+}
+
 func (s *Manager) handleOnItem(i interface{}) {
 	for _, fn := range s.onItemHandlers {
 		i = fn(i)
@@ -56,9 +89,24 @@ func (s *Manager) SetItemPoolSize(i int) {
 	s.itemPool.Tune(i)
 }
 
+// Run handles the items from workers until Stop is called,a Manager can't Run again after stopped
 func (s *Manager) Run() {
-	s.redis.Del(s.sName + DeduplicateSuffix)
+	defer s.itemPool.Release()
+	if err := s.backend.Clear(s.sName + DeduplicateSuffix); err != nil {
+		Log.Error(err)
+	}
+	if s.redis != nil {
+		resetRedisBloom(s.redis, s.sName+BloomSuffix)
+	}
 	for {
+		select {
+		case <-s.stop:
+			for s.itemPool.Running() > 0 {
+				time.Sleep(500 * time.Microsecond)
+			}
+			return
+		default:
+		}
 		if s.itemPool.Free() > 0 {
 			if i := s.GetItem(); i != nil {
 				err := s.itemPool.Submit(func() {
@@ -69,7 +117,10 @@ func (s *Manager) Run() {
 				}
 			} else if s.itemPool.Running() == 0 {
 				//Log.Info("Waiting for more items")
-				time.Sleep(5 * time.Second)
+				select {
+				case <-time.After(5 * time.Second):
+				case <-s.stop:
+				}
 			}
 		} else {
 			time.Sleep(500 * time.Microsecond)
@@ -78,140 +129,312 @@ func (s *Manager) Run() {
 	}
 }
 
+// Stop makes Run return after the running item handlers finish,the items left in backend are kept
+func (s *Manager) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// GetItem pops an item sent by workers,returns nil if there's none
 func (s *Manager) GetItem() interface{} {
-	res, err := s.redis.LPop(s.sName + ItemsSuffix).Bytes()
+	res, err := s.backend.Pop(s.sName + ItemsSuffix)
 	if err != nil {
-		if !errors.Is(err, redis.Nil) {
-			Log.Error(err)
-		}
+		s.handleOnError(err)
+		return nil
+	}
+	if res == nil {
 		return nil
 	}
-	dec := gob.NewDecoder(bytes.NewReader(res))
-	item := item{}
-	err = dec.Decode(&item)
+	i, err := DecodeItem(res)
 	if err != nil {
-		Log.Error(err)
+		s.handleOnError(DecodeErr{err, res})
+		return nil
 	}
-	return item.Data
+	return i
+}
+
+// ReapTasks re-queues the processing tasks of reliable workers without heartbeat for timeout,
+// returns the number of tasks re-queued
+func (s *Manager) ReapTasks(timeout time.Duration) (int, error) {
+	return reapTasks(s.backend, s.sName, timeout)
 }
 
-func (s *Manager) SendReq(req *Request) {
-	var buffer bytes.Buffer
-	ecoder := gob.NewEncoder(&buffer)
-	err := ecoder.Encode(req)
+// SendReq sends a seed task to the workers.The task is handled by the handlers registered by RegisterHandler
+// with handlerNames,or by the seed handler of workers if no name is given.
+func (s *Manager) SendReq(req *Request, handlerNames ...string) {
+	data, err := EncodeRequest(req, handlerNames...)
 	if err != nil {
-		Log.Error(err)
+		s.handleOnError(err)
 		return
 	}
-	err = s.redis.LPush(s.sName+TasksSuffix, buffer.Bytes()).Err()
+	err = s.backend.Push(s.sName+TasksSuffix, data)
 	if err != nil {
-		Log.Error(err)
+		s.handleOnError(err)
 	}
 }
 
-// Scheduler is default scheduler of goribot
-type RedisScheduler struct {
-	redis     *redis.Client
+// DistributedScheduler is a scheduler gets seed tasks from the QueueBackend and sends items to it.
+// The new tasks are kept locally.
+type DistributedScheduler struct {
+	tasks, items, errors uint64 // accessed atomically,keep them 64-bit aligned
+
+	queue     QueueBackend
 	sName     string
 	fn        []CtxHandlerFun
 	batchSize int
 	base      *BaseScheduler
+
+	workerID   string
+	reliable   bool
+	lock       sync.Mutex
+	processing map[*Task][]byte
+
+	spider          *Spider
+	registerOnce    sync.Once
+	interval        time.Duration
+	startTime       time.Time
+	paused, stopped int32
+	lastBeat        time.Time
+	lastTasks       uint64
+}
+
+// RedisScheduler is the DistributedScheduler based on redis
+type RedisScheduler = DistributedScheduler
+
+// NewDistributedScheduler creates a DistributedScheduler,bs is the number of tasks loaded at once,
+// fn are the handlers of seed tasks
+func NewDistributedScheduler(q QueueBackend, sName string, bs int, fn ...CtxHandlerFun) *DistributedScheduler {
+	return &DistributedScheduler{
+		queue:      q,
+		sName:      sName,
+		fn:         fn,
+		batchSize:  bs,
+		base:       NewBaseScheduler(false),
+		workerID:   newWorkerID(),
+		processing: map[*Task][]byte{},
+		interval:   WorkerHeartbeatInterval,
+	}
 }
 
+// NewRedisScheduler creates a DistributedScheduler based on redis
 func NewRedisScheduler(redis *redis.Client, sName string, bs int, fn ...CtxHandlerFun) *RedisScheduler {
-	return &RedisScheduler{redis, sName, fn, bs, NewBaseScheduler(false)}
+	return NewDistributedScheduler(NewRedisBackend(redis), sName, bs, fn...)
+}
+
+// SetReliable makes the scheduler at-least-once.The tasks got from backend are moved to the processing list of worker
+// instead of removed, and removed after the spider acks them.If the worker dies, the tasks are re-queued by the reaper,
+// see DistributedReliable. The worker id is generated if workerID is "".
+func (s *DistributedScheduler) SetReliable(workerID string) *DistributedScheduler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if workerID != "" {
+		s.workerID = workerID
+	}
+	s.reliable = true
+	return s
 }
-func (s *RedisScheduler) loadRedisTask() {
+
+// WorkerID returns the id of worker,which is the id in Manager.Workers as well
+func (s *DistributedScheduler) WorkerID() string {
+	return s.workerID
+}
+
+func (s *DistributedScheduler) processingKey() string {
+	return s.sName + ProcessingSuffix + "_" + s.workerID
+}
+
+func (s *DistributedScheduler) loadTask() {
 	i := 0
 	for i < s.batchSize {
-		res, err := s.redis.LPop(s.sName + TasksSuffix).Bytes()
+		var res []byte
+		var err error
+		if s.reliable {
+			res, err = s.queue.Move(s.sName+TasksSuffix, s.processingKey())
+		} else {
+			res, err = s.queue.Pop(s.sName + TasksSuffix)
+		}
 		if err != nil {
-			if !errors.Is(err, redis.Nil) {
-				Log.Error(err)
-			}
+			Log.Error(err)
+			return
+		}
+		if res == nil {
 			return
 		}
-		dec := gob.NewDecoder(bytes.NewReader(res))
-		req := &Request{}
-		err = dec.Decode(req)
-		s.base.AddTask(NewTask(req, s.fn...))
 		i += 1
+		var t *Task
+		if req, fns, err := DecodeRequest(res); err != nil {
+			// the task failing to decode is sent to OnError by spider
+			req = NewRequest(http.MethodGet, "", nil)
+			req.Err = DecodeErr{err, res}
+			t = NewTask(req)
+		} else if len(fns) > 0 {
+			t = NewTask(req, fns...)
+		} else {
+			t = NewTask(req, s.fn...)
+		}
+		if s.reliable {
+			s.lock.Lock()
+			s.processing[t] = res
+			s.lock.Unlock()
+		}
+		s.base.AddTask(t)
+	}
+}
+
+// AckTask counts the task done,and removes it from the processing list in reliable mode
+func (s *DistributedScheduler) AckTask(t *Task) {
+	atomic.AddUint64(&s.tasks, 1)
+	s.lock.Lock()
+	res, ok := s.processing[t]
+	delete(s.processing, t)
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+	if err := s.queue.Remove(s.processingKey(), res); err != nil {
+		Log.Error("ack task error", err)
 	}
 }
 
-func (s *RedisScheduler) GetTask() *Task {
+// GetTask returns nil if the worker is paused or stopped by Manager
+func (s *DistributedScheduler) GetTask() *Task {
+	if s.Paused() {
+		return nil
+	}
 	t := s.base.GetTask()
 	if t == nil {
-		s.loadRedisTask()
+		s.loadTask()
 		t = s.base.GetTask()
 	}
 	return t
 
 }
-func (s *RedisScheduler) GetItem() interface{} {
+func (s *DistributedScheduler) GetItem() interface{} {
 	return s.base.GetItem()
 }
-func (s *RedisScheduler) AddTask(t *Task) {
+func (s *DistributedScheduler) AddTask(t *Task) {
 	s.base.AddTask(t)
 }
-func (s *RedisScheduler) AddItem(i interface{}) {
-	s.base.AddItem(i)
-	var buffer bytes.Buffer
-	ecoder := gob.NewEncoder(&buffer)
-	err := ecoder.Encode(item{Data: i})
-	if err != nil {
+func (s *DistributedScheduler) AddItem(i interface{}) {
+	if err := s.TryAddItem(i); err != nil {
 		Log.Error(err)
-		return
 	}
-	err = s.redis.LPush(s.sName+ItemsSuffix, buffer.Bytes()).Err()
+}
+
+// TryAddItem adds the item and sends it to Manager,returns an EncodeErr if it can't be encoded
+func (s *DistributedScheduler) TryAddItem(i interface{}) error {
+	atomic.AddUint64(&s.items, 1)
+	s.base.AddItem(i)
+	data, err := EncodeItem(i)
 	if err != nil {
-		Log.Error(err)
-		return
+		return EncodeErr{err, i}
 	}
+	return s.queue.Push(s.sName+ItemsSuffix, data)
 }
-func (s *RedisScheduler) IsTaskEmpty() bool {
-	s.loadRedisTask()
-	return s.base.IsItemEmpty()
+func (s *DistributedScheduler) IsTaskEmpty() bool {
+	if s.Paused() {
+		return true
+	}
+	s.loadTask()
+	return s.base.IsTaskEmpty()
 }
-func (s *RedisScheduler) IsItemEmpty() bool {
-	l, err := s.redis.LLen(s.sName + ItemsSuffix).Result()
+func (s *DistributedScheduler) IsItemEmpty() bool {
+	l, err := s.queue.Len(s.sName + ItemsSuffix)
 	return l == 0 || err != nil
 }
 
-// ReqDeduplicate is an extension can deduplicate new task based on redis to support distributed
-func RedisReqDeduplicate(r *redis.Client, sName string) func(s *Spider) {
+// RedisReqDeduplicate is an extension can deduplicate new task based on redis to support distributed.
+// The identity of request is computed by the Fingerprinter if given, otherwise by GetRequestHash.
+func RedisReqDeduplicate(r *redis.Client, sName string, fp ...*Fingerprinter) func(s *Spider) {
+	return BackendReqDeduplicate(NewRedisBackend(r), sName, fp...)
+}
+
+// BackendReqDeduplicate is an extension can deduplicate new task based on the DedupBackend to support distributed.
+// The identity of request is computed by the Fingerprinter if given, otherwise by GetRequestHash.
+func BackendReqDeduplicate(d DedupBackend, sName string, fp ...*Fingerprinter) func(s *Spider) {
+	f := getFingerprinter(fp)
 	return func(s *Spider) {
-		s.OnAdd(func(ctx *Context, t *Task) *Task {
-			has := GetRequestHash(t.Request)
-			res, err := r.SAdd(sName+DeduplicateSuffix, has[:]).Result()
-			if err == nil && res == 0 {
-				return nil
-			}
-			return t
+		deduplicate(s, f, func(has [md5.Size]byte) bool {
+			added, err := d.Add(sName+DeduplicateSuffix, has[:])
+			return err == nil && !added
 		})
 	}
 }
 
+// RedisDistributed is an extension makes the spider a worker of distributed mode based on redis,see Distributed
 func RedisDistributed(ro *redis.Options, sName string, useDeduplicate bool, onSeedHandler CtxHandlerFun) func(s *Spider) {
-	c1 := redis.NewClient(ro)
-	if pong, err := c1.Ping().Result(); pong != "PONG" || err != nil {
+	c := redis.NewClient(ro)
+	if pong, err := c.Ping().Result(); pong != "PONG" || err != nil {
 		panic("redis connect error " + fmt.Sprint(pong, err))
 	}
-	var c2 *redis.Client
-	if useDeduplicate {
-		c2 = redis.NewClient(ro)
-		if pong, err := c2.Ping().Result(); pong != "PONG" || err != nil {
-			panic("redis connect error " + fmt.Sprint(pong, err))
-		}
+	ext := Distributed(NewRedisBackend(c), sName, useDeduplicate, onSeedHandler)
+	return func(s *Spider) {
+		ext(s)
+		s.OnFinish(func(s *Spider) {
+			_ = c.Close()
+		})
 	}
+}
+
+// Distributed is an extension makes the spider a worker of distributed mode.
+// The seed tasks are got from the Backend and handled by onSeedHandler, and the items are sent to the Manager.
+// If useDeduplicate is set, the new tasks are deduplicated among workers by BackendReqDeduplicate.
+// The worker registers itself and sends heartbeats with its stats every WorkerHeartbeatInterval,
+// and handles the commands from Manager,see Manager.Workers and Manager.Broadcast.
+func Distributed(b Backend, sName string, useDeduplicate bool, onSeedHandler CtxHandlerFun) func(s *Spider) {
 	return func(s *Spider) {
-		s.Scheduler = NewRedisScheduler(c1, sName, 10, onSeedHandler)
+		rs := NewDistributedScheduler(b, sName, 10, onSeedHandler)
+		rs.register(s)
+		s.Scheduler = rs
 		if useDeduplicate {
-			s.Use(RedisReqDeduplicate(c2, sName))
+			s.Use(BackendReqDeduplicate(b, sName))
 		}
 		s.AutoStop = false
+	}
+}
+
+// DistributedReliable is an extension makes the DistributedScheduler of spider at-least-once,
+// see DistributedScheduler.SetReliable. It should be used after Distributed or RedisDistributed. The worker sends heartbeat
+// at least every timeout/3, and re-queues the processing tasks of the workers without heartbeat for timeout.
+func DistributedReliable(timeout time.Duration) func(s *Spider) {
+	return func(s *Spider) {
+		rs, ok := s.Scheduler.(*DistributedScheduler)
+		if !ok {
+			panic("spider is not using DistributedScheduler from goribot")
+		}
+		rs.SetReliable("")
+		if rs.interval > timeout/3 {
+			rs.interval = timeout / 3
+		}
+		rs.register(s)
+		stop := make(chan struct{})
+		s.OnStart(func(s *Spider) {
+			go func() {
+				t := time.NewTicker(timeout / 3)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+					case <-stop:
+						return
+					}
+					if n, err := reapTasks(rs.queue, rs.sName, timeout); err != nil {
+						Log.Error("reap tasks error", err)
+					} else if n > 0 {
+						Log.Info("re-queued", n, "tasks of dead workers")
+					}
+				}
+			}()
+		})
 		s.OnFinish(func(s *Spider) {
-			_ = c1.Close()
+			close(stop)
 		})
 	}
 }
+
+// newWorkerID returns a unique id of worker from hostname and pid
+func newWorkerID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%x", host, os.Getpid(), rand.Uint32())
+}
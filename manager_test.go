@@ -52,3 +52,57 @@ func TestManager(t *testing.T) {
 		t.Error("lost item")
 	}
 }
+
+func TestRedisReliable(t *testing.T) {
+	if os.Getenv("DISABLE_SAVER_TEST") == "" {
+		return
+	}
+	ro := &redis.Options{Addr: "localhost:6379"}
+	sName := "ReliableTest"
+	r := redis.NewClient(ro)
+	r.Del(sName+TasksSuffix, sName+WorkersSuffix)
+	m := NewManager(r, sName)
+	m.SendReq(GetReq("https://httpbin.org/get"))
+
+	// a worker got the task and died without ack
+	dead := NewRedisScheduler(redis.NewClient(ro), sName, 10).SetReliable("dead-worker")
+	if err := dead.heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if task := dead.GetTask(); task == nil {
+		t.Fatal("lost task")
+	}
+	if n, _ := r.LLen(sName + TasksSuffix).Result(); n != 0 {
+		t.Error("task isn't moved to processing list", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n, err := m.ReapTasks(50 * time.Millisecond); n != 1 || err != nil {
+		t.Error("task isn't re-queued", n, err)
+	}
+
+	got := false
+	s := NewSpider(
+		RedisDistributed(ro, sName, false, func(ctx *Context) {
+			got = true
+		}),
+		DistributedReliable(time.Second),
+	)
+	rs := s.Scheduler.(*RedisScheduler)
+	go s.Run()
+	time.Sleep(10 * time.Second)
+	if !got {
+		t.Error("lost resp")
+	}
+	if n, _ := r.LLen(rs.processingKey()).Result(); n != 0 {
+		t.Error("task isn't acked", n)
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	if os.Getenv("DISABLE_SAVER_TEST") == "" {
+		return
+	}
+	r := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	r.Del("q", "p", "w", "s")
+	testBackend(t, NewRedisBackend(r))
+}
@@ -1,20 +1,38 @@
 package goribot
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/saintfish/chardet"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
 	"github.com/tidwall/gjson"
+	"golang.org/x/net/html"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/textproto"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 )
 
+var ErrNoDom = errors.New("response has no parsed html document")
+var ErrNoXMLDom = errors.New("response has no parsed xml document")
+var ErrTooManyRedirects = errors.New("stopped after too many redirects")
+
+// DefaultMaxRedirects is the max number of redirects followed if Request.MaxRedirects isn't set
+const DefaultMaxRedirects = 10
+
 // DownloaderErr is a error create by Downloader
 type DownloaderErr struct {
 	error
@@ -24,12 +42,17 @@ type DownloaderErr struct {
 	Response *Response
 }
 
+// Unwrap returns the underlying error,so errors.Is works with DownloaderErr
+func (e DownloaderErr) Unwrap() error {
+	return e.error
+}
+
 // Deprecated: will be remove at next major version
 var GetReq = Get
 
-// Get creates a get request
-func Get(urladdr string) *Request {
-	req, err := http.NewRequest("GET", urladdr, nil)
+// NewRequest creates a request with the method,url and body
+func NewRequest(method, urladdr string, body io.Reader) *Request {
+	req, err := http.NewRequest(method, urladdr, body)
 	return &Request{
 		Request:                   req,
 		Depth:                     -1,
@@ -40,20 +63,37 @@ func Get(urladdr string) *Request {
 	}
 }
 
+// Get creates a get request
+func Get(urladdr string) *Request {
+	return NewRequest("GET", urladdr, nil)
+}
+
+// Head creates a head request
+func Head(urladdr string) *Request {
+	return NewRequest("HEAD", urladdr, nil)
+}
+
+// Delete creates a delete request
+func Delete(urladdr string) *Request {
+	return NewRequest("DELETE", urladdr, nil)
+}
+
 // Deprecated: will be remove at next major version
 var PostReq = Post
 
 // Post creates a post request
 func Post(urladdr string, body io.Reader) *Request {
-	req, err := http.NewRequest("POST", urladdr, body)
-	return &Request{
-		Request:                   req,
-		Depth:                     -1,
-		ResponseCharacterEncoding: "",
-		ProxyURL:                  "",
-		Meta:                      map[string]interface{}{},
-		Err:                       err,
-	}
+	return NewRequest("POST", urladdr, body)
+}
+
+// Put creates a put request
+func Put(urladdr string, body io.Reader) *Request {
+	return NewRequest("PUT", urladdr, body)
+}
+
+// Patch creates a patch request
+func Patch(urladdr string, body io.Reader) *Request {
+	return NewRequest("PATCH", urladdr, body)
 }
 
 // PostReq creates a post request with raw data
@@ -84,12 +124,80 @@ func PostJsonReq(urladdr string, requestData interface{}) *Request {
 	return req
 }
 
+// MultipartFile is a file part of multipart request
+type MultipartFile struct {
+	// Field is the form field name
+	Field string
+	// FileName is the name of file
+	FileName string
+	// ContentType is the type of file,application/octet-stream by default
+	ContentType string
+	// Reader is the content of file,which is read when creating the request
+	Reader io.Reader
+}
+
+// multipartBody encodes the fields and files as multipart/form-data
+func multipartBody(fields url.Values, files []MultipartFile) ([]byte, string, error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range fields[k] {
+			if err := w.WriteField(k, v); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for _, f := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": f.Field, "filename": f.FileName}))
+		ct := f.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h.Set("Content-Type", ct)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		if f.Reader != nil {
+			if _, err = io.Copy(part, f.Reader); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), w.FormDataContentType(), nil
+}
+
+// PostMultipartReq creates a post request with multipart form data,including fields and files
+func PostMultipartReq(urladdr string, fields map[string]string, files ...MultipartFile) *Request {
+	values := url.Values{}
+	for k, v := range fields {
+		values.Set(k, v)
+	}
+	body, contentType, err := multipartBody(values, files)
+	req := PostRawReq(urladdr, body)
+	if req.Err == nil {
+		req.Err = err
+	}
+	req.SetHeader("Content-Type", contentType)
+	return req
+}
+
 // Request is a object of HTTP request
 type Request struct {
 	*http.Request
 	Depth int
 	// ResponseCharacterEncoding is the character encoding of the response body.
 	// Leave it blank to allow automatic character encoding of the response body.
+	// It overrides the html meta and Content-Type header,only a BOM is preferred over it.
 	// It is empty by default and it can be set in OnRequest callback.
 	ResponseCharacterEncoding string
 	// ProxyURL is the proxy address that handles the request
@@ -97,20 +205,34 @@ type Request struct {
 	// Meta contains data between a Request and a Response
 	Meta map[string]interface{}
 	Err  error
+	// MaxRedirects is the max number of redirects to follow,DefaultMaxRedirects is used if it's 0
+	MaxRedirects int
+	// NoRedirect makes the downloader return the redirect response instead of following it
+	NoRedirect bool
 
-	body []byte
+	body       []byte
+	onRedirect func(hop *RedirectHop) bool
 }
 
 // GetBody returns the body as bytes of request
 func (s *Request) GetBody() []byte {
-	if s.Err == nil {
-		if s.Request.Body == nil {
-			return []byte{}
+	if s.Err != nil || s.Request.Body == nil {
+		return []byte{}
+	}
+	if s.body == nil {
+		if s.Request.GetBody != nil {
+			if rc, err := s.Request.GetBody(); err == nil {
+				s.body, err = ioutil.ReadAll(rc)
+				_ = rc.Close()
+				if err == nil {
+					return s.body
+				}
+			}
 		}
 		s.body, _ = ioutil.ReadAll(s.Request.Body)
 		s.Request.Body = ioutil.NopCloser(bytes.NewReader(s.body))
 	}
-	return []byte{}
+	return s.body
 }
 
 // AddCookie adds a cookie to the request.
@@ -166,6 +288,18 @@ func (s *Request) SetParam(p map[string]string) *Request {
 	return s
 }
 
+// SetMaxRedirects sets the max number of redirects to follow
+func (s *Request) SetMaxRedirects(n int) *Request {
+	s.MaxRedirects = n
+	return s
+}
+
+// SetNoRedirect sets whether to return the redirect response instead of following it
+func (s *Request) SetNoRedirect(b bool) *Request {
+	s.NoRedirect = b
+	return s
+}
+
 // SetParam sets the meta data of request.
 func (s *Request) WithMeta(k string, v interface{}) *Request {
 	s.Meta[k] = v
@@ -183,39 +317,39 @@ type Response struct {
 	Req *Request
 	// Dom is the parsed html object
 	Dom *goquery.Document
+	// XMLDom is the parsed xml object
+	XMLDom *xmlquery.Node
+	// Redirects are the redirects followed before getting the response,in order
+	Redirects []*RedirectHop
+	// Encoding is the canonical name of the character encoding used to decode the body,e.g. "gbk"
+	Encoding string
+	// EncodingSource is where the Encoding comes from
+	EncodingSource EncodingSource
 	// Meta contains data between a Request and a Response
 	Meta map[string]interface{}
 }
 
-// DecodeAndParas decodes the body to text and try to parse it to html or json.
+// DecodeAndParas decodes the body to text and try to parse it to html, xml or json.
 func (s *Response) DecodeAndParse() error {
 	if len(s.Body) == 0 {
 		return nil
 	}
 	contentType := strings.ToLower(s.Header.Get("Content-Type"))
 	if strings.Contains(contentType, "text/") ||
-		strings.Contains(contentType, "/json") {
-		if !strings.Contains(contentType, "charset") {
-			if s.Req.ResponseCharacterEncoding != "" {
-				contentType += "; charset=" + s.Req.ResponseCharacterEncoding
-			} else {
-				r, err := chardet.NewTextDetector().DetectBest(s.Body)
-				if err != nil {
-					return err
-				}
-				contentType += "; charset=" + r.Charset
-			}
+		strings.Contains(contentType, "/json") || s.IsXML() {
+		e, name, source, bomLen, err := s.sniffEncoding()
+		if err != nil {
+			return err
 		}
-		if strings.Contains(contentType, "utf-8") || strings.Contains(contentType, "utf8") {
-			s.Text = string(s.Body)
-		} else {
-			tmpBody, err := encodeBytes(s.Body, contentType)
-			if err != nil {
+		s.Encoding, s.EncodingSource = name, source
+		body := s.Body[bomLen:]
+		if name != "utf-8" {
+			if body, err = e.NewDecoder().Bytes(body); err != nil {
 				return err
 			}
-			s.Body = tmpBody
-			s.Text = string(s.Body)
 		}
+		s.Body = body
+		s.Text = string(s.Body)
 		if s.IsHTML() {
 			d, err := goquery.NewDocumentFromReader(bytes.NewReader(s.Body))
 			s.Dom = d
@@ -223,6 +357,13 @@ func (s *Response) DecodeAndParse() error {
 				return err
 			}
 		}
+		if s.IsXML() {
+			d, err := xmlquery.Parse(strings.NewReader(xmlDeclUTF8(s.Text)))
+			s.XMLDom = d
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -232,6 +373,82 @@ func (s *Response) Json(q string) gjson.Result {
 	return gjson.Get(s.Text, q)
 }
 
+// FindAllSubmatch returns all matches of the regexp in Text,each match is a map from the group name to the submatch.
+// The unnamed groups are skipped.
+func (s *Response) FindAllSubmatch(re *regexp.Regexp) []map[string]string {
+	var res []map[string]string
+	names := re.SubexpNames()
+	for _, m := range re.FindAllStringSubmatch(s.Text, -1) {
+		r := map[string]string{}
+		for i, n := range names {
+			if n != "" {
+				r[n] = m[i]
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// FindSubmatch returns the first match of the regexp in Text like FindAllSubmatch,or nil if not matched
+func (s *Response) FindSubmatch(re *regexp.Regexp) map[string]string {
+	names := re.SubexpNames()
+	m := re.FindStringSubmatch(s.Text)
+	if m == nil {
+		return nil
+	}
+	r := map[string]string{}
+	for i, n := range names {
+		if n != "" {
+			r[n] = m[i]
+		}
+	}
+	return r
+}
+
+// ScriptJSON returns the json object or array assigned to the javascript variable in the response,
+// e.g. ScriptJSON("window.__INITIAL_STATE__") for `<script>window.__INITIAL_STATE__ = {...};</script>`.
+// Only the <script> elements are searched if the response is html.
+func (s *Response) ScriptJSON(name string) gjson.Result {
+	re := regexp.MustCompile(regexp.QuoteMeta(name) + `\s*=\s*`)
+	var scripts []string
+	if s.Dom != nil {
+		s.Dom.Find("script").Each(func(i int, sel *goquery.Selection) {
+			scripts = append(scripts, sel.Text())
+		})
+	} else {
+		scripts = []string{s.Text}
+	}
+	for _, script := range scripts {
+		for _, loc := range re.FindAllStringIndex(script, -1) {
+			if j := jsonBlob(script[loc[1]:]); j != "" {
+				return gjson.Parse(j)
+			}
+		}
+	}
+	return gjson.Result{}
+}
+
+// XPath returns the nodes matched by the XPath 1.0 expression in the parsed html.
+// It works on the same tree as Dom,attribute and text results are returned as new nodes.
+func (s *Response) XPath(expr string) (*goquery.Selection, error) {
+	if s.Dom == nil {
+		return nil, ErrNoDom
+	}
+	exp, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return xpathSelection(s.Dom, htmlquery.QuerySelectorAll(s.Dom.Nodes[0], exp)...), nil
+}
+
+// xpathSelection makes a selection of the nodes,which may not be in the dom
+func xpathSelection(dom *goquery.Document, nodes ...*html.Node) *goquery.Selection {
+	sel := dom.FindNodes()
+	sel.Nodes = nodes
+	return sel
+}
+
 func (s *Response) IsHTML() bool {
 	contentType := strings.ToLower(s.Header.Get("Content-Type"))
 	return strings.Contains(contentType, "/html")
@@ -242,6 +459,106 @@ func (s *Response) IsJSON() bool {
 	return strings.Contains(contentType, "/json")
 }
 
+// IsXML returns whether the response is xml,including rss,atom and soap,or a .xml.gz file
+func (s *Response) IsXML() bool {
+	contentType := strings.ToLower(s.Header.Get("Content-Type"))
+	if strings.Contains(contentType, "/xml") || strings.Contains(contentType, "+xml") {
+		return true
+	}
+	return s.Req != nil && strings.HasSuffix(strings.ToLower(s.Req.URL.Path), ".xml.gz")
+}
+
+var xmlDeclEncodingRegexp = regexp.MustCompile(`^\s*<\?xml[^>]*?\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// xmlDeclEncoding returns the encoding in the xml declaration of b
+func xmlDeclEncoding(b []byte) string {
+	if len(b) > 1024 {
+		b = b[:1024]
+	}
+	if m := xmlDeclEncodingRegexp.FindSubmatch(b); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	return ""
+}
+
+// xmlDeclUTF8 changes the encoding in the xml declaration to utf-8,since the text is decoded already
+func xmlDeclUTF8(text string) string {
+	if loc := xmlDeclEncodingRegexp.FindStringSubmatchIndex(text); loc != nil {
+		return text[:loc[2]] + "utf-8" + text[loc[3]:]
+	}
+	return text
+}
+
+// XMLQuery returns the nodes matched by the XPath 1.0 expression in the parsed xml
+func (s *Response) XMLQuery(expr string) ([]*xmlquery.Node, error) {
+	if s.XMLDom == nil {
+		return nil, ErrNoXMLDom
+	}
+	exp, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return xmlquery.QuerySelectorAll(s.XMLDom, exp), nil
+}
+
+// baseURL returns the url that relative links in the response resolve against,
+// which is the <base href> if exists or the url of response.
+func (s *Response) baseURL() *url.URL {
+	u := s.Req.URL
+	if s.Response != nil && s.Response.Request != nil {
+		u = s.Response.Request.URL
+	}
+	if s.Dom != nil {
+		if href, ok := s.Dom.Find("base[href]").First().Attr("href"); ok {
+			if b, err := u.Parse(strings.TrimSpace(href)); err == nil {
+				return b
+			}
+		}
+	}
+	return u
+}
+
+// Canonical returns the canonical url of page from <link rel="canonical"> or the Link header,nil if there's none
+func (s *Response) Canonical() *url.URL {
+	base := s.baseURL()
+	if s.Dom != nil {
+		var res *url.URL
+		s.Dom.Find("link[rel][href]").EachWithBreak(func(i int, sel *goquery.Selection) bool {
+			if hasToken(sel.AttrOr("rel", ""), "canonical") {
+				if u, err := base.Parse(strings.TrimSpace(sel.AttrOr("href", ""))); err == nil {
+					res = u
+					return false
+				}
+			}
+			return true
+		})
+		if res != nil {
+			return res
+		}
+	}
+	if s.Response == nil {
+		return nil
+	}
+	for _, h := range s.Header.Values("Link") {
+		for _, l := range strings.Split(h, ",") {
+			parts := strings.Split(l, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "rel") && hasToken(strings.Trim(kv[1], `"`), "canonical") {
+					if u, err := base.Parse(target[1 : len(target)-1]); err == nil {
+						return u
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // Downloader tool download response from request
 type Downloader interface {
 	Do(req *Request) (resp *Response, err error)
@@ -251,15 +568,80 @@ type Downloader interface {
 var D = NewBaseDownloader()
 var Do = D.Do
 
+// RedirectHop is a redirect followed by BaseDownloader,the hops of a response are kept in Response.Redirects
+type RedirectHop struct {
+	// From is the url of the redirect response
+	From *url.URL
+	// To is the url redirected to
+	To *url.URL
+	// StatusCode is the status code of the redirect response
+	StatusCode int
+	// Header is the header of the redirect response
+	Header http.Header
+}
+
+type downloadStateKey struct{}
+
+// downloadState is put in the context of http request to pass the options of Request and record the redirects
+type downloadState struct {
+	req  *Request
+	hops []*RedirectHop
+}
+
+// checkRedirect is the redirect policy of BaseDownloader,which follows the options of Request and records the hops
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	state, ok := req.Context().Value(downloadStateKey{}).(*downloadState)
+	if !ok {
+		if len(via) > DefaultMaxRedirects {
+			return ErrTooManyRedirects
+		}
+		return nil
+	}
+	if state.req.NoRedirect {
+		return http.ErrUseLastResponse
+	}
+	max := state.req.MaxRedirects
+	if max <= 0 {
+		max = DefaultMaxRedirects
+	}
+	if len(via) > max {
+		return ErrTooManyRedirects
+	}
+	hop := &RedirectHop{From: via[len(via)-1].URL, To: req.URL}
+	if req.Response != nil {
+		hop.StatusCode = req.Response.StatusCode
+		hop.Header = req.Response.Header
+	}
+	if state.req.onRedirect != nil && !state.req.onRedirect(hop) {
+		return http.ErrUseLastResponse
+	}
+	state.hops = append(state.hops, hop)
+	return nil
+}
+
 // BaseDownloader is default downloader of goribot
 type BaseDownloader struct {
-	Client   *http.Client
-	handlers []func(req *Request, next func(req *Request) (resp *Response, err error)) (resp *Response, err error)
+	Client *http.Client
+	// AcceptCompression sets Accept-Encoding to AcceptEncoding for the requests without one.
+	// The responses compressed by gzip, deflate, br or zstd are decompressed whether it's enabled or not.
+	AcceptCompression bool
+	handlers          []func(req *Request, next func(req *Request) (resp *Response, err error)) (resp *Response, err error)
+
+	transport       *http.Transport
+	dialer          *net.Dialer
+	skipVerifyHosts map[string]struct{}
+	dns             *DNSCache
 }
 
-func NewBaseDownloader() *BaseDownloader {
+// NewBaseDownloader creates a downloader with a cookie jar and a transport shared by all requests,
+// which could be configured by the options like WithInsecureSkipVerify and WithHTTP2.
+func NewBaseDownloader(opts ...DownloaderOption) *BaseDownloader {
 	j, _ := cookiejar.New(nil)
-	return &BaseDownloader{Client: &http.Client{Jar: j}}
+	d := &BaseDownloader{}
+	d.newTransport()
+	d.Client = &http.Client{Jar: j, CheckRedirect: checkRedirect, Transport: d.transport}
+	d.Apply(opts...)
+	return d
 }
 
 func (s *BaseDownloader) AddMiddleware(fn func(req *Request, next func(*Request) (*Response, error)) (*Response, error)) {
@@ -271,35 +653,35 @@ func (s *BaseDownloader) defaultHandler(req *Request) (resp *Response, err error
 		return nil, err
 	}
 	client := s.Client
-
-	if req.ProxyURL != "" {
-		s.Client.Transport = &http.Transport{
-			Proxy: func(request *http.Request) (u *url.URL, err error) {
-				return url.Parse(req.ProxyURL)
-			},
-		}
+	if s.AcceptCompression && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", AcceptEncoding)
 	}
-	res, err := client.Do(req.Request)
+	state := &downloadState{req: req}
+	res, err := client.Do(req.Request.WithContext(context.WithValue(req.Request.Context(), downloadStateKey{}, state)))
 	if err != nil {
 		return nil, DownloaderErr{err, req, resp}
 	}
 	defer res.Body.Close()
 
 	resp = &Response{
-		Response: res,
-		Text:     "",
-		Req:      req,
-		Meta:     req.Meta,
+		Response:  res,
+		Text:      "",
+		Req:       req,
+		Meta:      req.Meta,
+		Redirects: state.hops,
 	}
 
-	bodyReader := res.Body
-	contentEncoding := strings.ToLower(res.Header.Get("Content-Encoding"))
-	if !res.Uncompressed && (strings.Contains(contentEncoding, "gzip") || (contentEncoding == "" && strings.Contains(strings.ToLower(res.Header.Get("Content-Type")), "gzip")) || strings.HasSuffix(strings.ToLower(req.URL.Path), ".xml.gz")) {
-		bodyReader, err = gzip.NewReader(bodyReader)
+	br := bufio.NewReader(res.Body)
+	var bodyReader io.Reader = br
+	// responses of HEAD, 204, 304 and empty bodies may carry a Content-Encoding without data to decompress
+	if _, peekErr := br.Peek(1); !res.Uncompressed && peekErr == nil && req.Method != http.MethodHead &&
+		res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotModified {
+		var closeReader func()
+		bodyReader, closeReader, err = decompress(bodyReader, contentEncodings(resp))
 		if err != nil {
 			return nil, DownloaderErr{err, req, resp}
 		}
-		defer bodyReader.(*gzip.Reader).Close()
+		defer closeReader()
 	}
 
 	resp.Body, err = ioutil.ReadAll(bodyReader)
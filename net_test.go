@@ -1,9 +1,24 @@
 package goribot
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/brotli"
+	"github.com/antchfx/xmlquery"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -135,3 +150,375 @@ func TestCookieJar(t *testing.T) {
 	resp, _ = d.Do(GetReq(ts.URL))
 	fmt.Println(resp.Cookies())
 }
+
+func TestXPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, `<html><body><h1>Hello goribot</h1><ul><li><a href="/1">one</a></li><li><a href="/2">two</a></li></ul></body></html>`)
+	}))
+	defer ts.Close()
+
+	var hrefs []string
+	s := NewSpider()
+	s.OnXPath("//li/a/@href", func(ctx *Context, sel *goquery.Selection) {
+		hrefs = append(hrefs, sel.Text())
+	})
+	s.AddTask(GetReq(ts.URL), func(ctx *Context) {
+		sel, err := ctx.Resp.XPath("//ul/li[2]/a")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if sel.Text() != "two" || sel.AttrOr("href", "") != "/2" {
+			t.Error("wrong xpath result", sel.Text())
+		}
+		if sel.Closest("ul").Length() != 1 {
+			t.Error("xpath result isn't in dom")
+		}
+		if _, err := ctx.Resp.XPath("//ul["); err == nil {
+			t.Error("bad expression got no error")
+		}
+	})
+	s.Run()
+	if len(hrefs) != 2 || hrefs[0] != "/1" {
+		t.Error("wrong OnXPath result", hrefs)
+	}
+}
+
+func TestXML(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		body, _ := simplifiedchinese.GBK.NewEncoder().String(`<?xml version="1.0" encoding="GBK"?>
+<rss version="2.0"><channel><title>测试</title>
+<item><title>第一篇</title><link>https://example.com/1</link></item>
+<item><title>第二篇</title><link>https://example.com/2</link></item>
+</channel></rss>`)
+		_, _ = fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	var titles []string
+	s := NewSpider()
+	s.OnXML("//item/title", func(ctx *Context, n *xmlquery.Node) {
+		titles = append(titles, n.InnerText())
+	})
+	s.AddTask(GetReq(ts.URL), func(ctx *Context) {
+		if !ctx.Resp.IsXML() || ctx.Resp.Dom != nil {
+			t.Error("wrong content type")
+		}
+		links, err := ctx.Resp.XMLQuery("//item/link")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(links) != 2 || links[1].InnerText() != "https://example.com/2" {
+			t.Error("wrong xml query result")
+		}
+	})
+	s.Run()
+	if len(titles) != 2 || titles[0] != "第一篇" {
+		t.Error("wrong OnXML result", titles)
+	}
+}
+
+func TestRegexpAndScriptJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, `<html><body><p>price: 12 USD, 30 EUR</p>
+<script>var a = 1;window.__INITIAL_STATE__ = {"user":{"name":"go}ri'bot","tags":["a","b"]},"n":2};</script>
+</body></html>`)
+	}))
+	defer ts.Close()
+
+	var prices []string
+	s := NewSpider()
+	s.OnRegexp(`(\d+) (USD|EUR)`, func(ctx *Context, match []string) {
+		prices = append(prices, match[1]+match[2])
+	})
+	s.AddTask(GetReq(ts.URL), func(ctx *Context) {
+		re := regexp.MustCompile(`(?P<num>\d+) (?P<cur>[A-Z]{3})`)
+		m := ctx.Resp.FindAllSubmatch(re)
+		if len(m) != 2 || m[1]["num"] != "30" || m[1]["cur"] != "EUR" {
+			t.Error("wrong FindAllSubmatch result", m)
+		}
+		if ctx.Resp.FindSubmatch(re)["num"] != "12" {
+			t.Error("wrong FindSubmatch result")
+		}
+		j := ctx.Resp.ScriptJSON("window.__INITIAL_STATE__")
+		if j.Get("user.name").String() != "go}ri'bot" || j.Get("n").Int() != 2 || len(j.Get("user.tags").Array()) != 2 {
+			t.Error("wrong ScriptJSON result", j.Raw)
+		}
+		if ctx.Resp.ScriptJSON("window.nothing").Exists() {
+			t.Error("ScriptJSON got an unexisting var")
+		}
+	})
+	s.Run()
+	if len(prices) != 2 || prices[0] != "12USD" {
+		t.Error("wrong OnRegexp result", prices)
+	}
+}
+
+func TestEncodingSniff(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String(`<html><head><meta charset="gbk"><title>你好</title></head><body>测试</body></html>`)
+	sjis, _ := japanese.ShiftJIS.NewEncoder().String(`<html><head><meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS"></head><body>テスト</body></html>`)
+	cases := []struct {
+		path, contentType, body string
+		encoding                string
+		source                  EncodingSource
+		text                    string
+	}{
+		{"/meta", "text/html", gbk, "gbk", EncodingSourceMeta, "测试"},
+		{"/meta-over-header", "text/html; charset=iso-8859-1", gbk, "gbk", EncodingSourceMeta, "测试"},
+		{"/http-equiv", "text/html", sjis, "shift_jis", EncodingSourceMeta, "テスト"},
+		{"/bom", "text/html; charset=gbk", "\xEF\xBB\xBF<p>测试</p>", "utf-8", EncodingSourceBOM, "测试"},
+		{"/header", "text/plain; charset=utf-8", "测试", "utf-8", EncodingSourceHeader, "测试"},
+	}
+	big5, _ := traditionalchinese.Big5.NewEncoder().String("測試")
+	overrides := []struct {
+		path, contentType, body string
+		encoding                string
+		source                  EncodingSource
+		text                    string
+	}{
+		{"/override-meta", "text/html", `<html><head><meta charset="gbk"></head><body>` + big5 + `</body></html>`, "big5", EncodingSourceRequest, "測試"},
+		{"/override-header", "text/plain; charset=iso-8859-1", big5, "big5", EncodingSourceRequest, "測試"},
+		{"/override-bom", "text/html", "\xEF\xBB\xBF<p>测试</p>", "utf-8", EncodingSourceBOM, "测试"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range append(cases, overrides...) {
+			if c.path == r.URL.Path {
+				w.Header().Set("Content-Type", c.contentType)
+				_, _ = fmt.Fprint(w, c.body)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	d := NewBaseDownloader()
+	for _, c := range cases {
+		resp, err := d.Do(GetReq(ts.URL + c.path))
+		if err != nil {
+			t.Fatal(c.path, err)
+		}
+		if resp.Encoding != c.encoding || resp.EncodingSource != c.source {
+			t.Error(c.path, "wrong encoding", resp.Encoding, resp.EncodingSource)
+		}
+		if !strings.Contains(resp.Text, c.text) || strings.HasPrefix(resp.Text, "\uFEFF") {
+			t.Error(c.path, "wrong text", resp.Text)
+		}
+	}
+
+	// the encoding of request overrides meta and header,but not BOM
+	for _, c := range overrides {
+		req := GetReq(ts.URL + c.path)
+		req.ResponseCharacterEncoding = "big5"
+		resp, err := d.Do(req)
+		if err != nil {
+			t.Fatal(c.path, err)
+		}
+		if resp.Encoding != c.encoding || resp.EncodingSource != c.source {
+			t.Error(c.path, "wrong encoding", resp.Encoding, resp.EncodingSource)
+		}
+		if !strings.Contains(resp.Text, c.text) {
+			t.Error(c.path, "wrong text", resp.Text)
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	const text = "hello goribot,hello goribot,hello goribot"
+	encode := func(e string, b []byte) []byte {
+		buf := &bytes.Buffer{}
+		var w io.WriteCloser
+		switch e {
+		case "gzip":
+			w = gzip.NewWriter(buf)
+		case "deflate":
+			w = zlib.NewWriter(buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(buf)
+		case "zstd":
+			w, _ = zstd.NewWriter(buf)
+		}
+		_, _ = w.Write(b)
+		_ = w.Close()
+		return buf.Bytes()
+	}
+	var acceptEncoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		b := []byte(text)
+		var applied []string
+		for _, e := range strings.Split(r.URL.Query().Get("e"), ",") {
+			b = encode(e, b)
+			if e == "raw-deflate" {
+				e = "deflate"
+			}
+			applied = append(applied, e)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Encoding", strings.Join(applied, ", "))
+		_, _ = w.Write(b)
+	}))
+	defer ts.Close()
+
+	d := NewBaseDownloader()
+	d.AcceptCompression = true
+	for _, e := range []string{"gzip", "deflate", "raw-deflate", "br", "zstd", "gzip,br"} {
+		resp, err := d.Do(GetReq(ts.URL + "/?e=" + e))
+		if err != nil {
+			t.Fatal(e, err)
+		}
+		if resp.Text != text {
+			t.Error(e, "wrong text", resp.Text)
+		}
+	}
+	if acceptEncoding != AcceptEncoding {
+		t.Error("wrong Accept-Encoding", acceptEncoding)
+	}
+
+	// the responses without body aren't decompressed
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", r.URL.Query().Get("e"))
+		switch r.URL.Path {
+		case "/204":
+			w.WriteHeader(http.StatusNoContent)
+		case "/304":
+			w.WriteHeader(http.StatusNotModified)
+		case "/head":
+			w.Header().Set("Content-Length", "100")
+		}
+	}))
+	defer empty.Close()
+	for _, req := range []*Request{
+		Head(empty.URL + "/head?e=gzip"),
+		Head(empty.URL + "/head?e=deflate"),
+		Get(empty.URL + "/204?e=gzip"),
+		Get(empty.URL + "/304?e=zstd"),
+		Get(empty.URL + "/empty?e=gzip"),
+		Get(empty.URL + "/empty?e=deflate"),
+	} {
+		resp, err := d.Do(req)
+		if err != nil {
+			t.Error(req.Method, req.URL, err)
+		} else if len(resp.Body) != 0 {
+			t.Error(req.Method, req.URL, "wrong body", resp.Body)
+		}
+	}
+}
+
+func TestRequestBuilders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+				return
+			}
+			f, h, err := r.FormFile("file")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer f.Close()
+			b, _ := ioutil.ReadAll(f)
+			_, _ = fmt.Fprint(w, r.FormValue("name"), " ", h.Filename, " ", h.Header.Get("Content-Type"), " ", string(b))
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprint(w, r.Method, " ", string(b))
+	}))
+	defer ts.Close()
+
+	d := NewBaseDownloader()
+	for want, req := range map[string]*Request{
+		"PUT put":     Put(ts.URL, strings.NewReader("put")),
+		"PATCH patch": Patch(ts.URL, strings.NewReader("patch")),
+		"DELETE ":     Delete(ts.URL),
+		"OPTIONS a":   NewRequest("OPTIONS", ts.URL, strings.NewReader("a")),
+		"goribot a.txt text/plain hello": PostMultipartReq(ts.URL, map[string]string{"name": "goribot"}, MultipartFile{
+			Field: "file", FileName: "a.txt", ContentType: "text/plain", Reader: strings.NewReader("hello"),
+		}),
+	} {
+		resp, err := d.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != want {
+			t.Error("wrong response", resp.Text, "want", want)
+		}
+	}
+	resp, err := d.Do(Head(ts.URL))
+	if err != nil || resp.StatusCode != 200 || len(resp.Body) != 0 {
+		t.Error("wrong head response", err)
+	}
+	if NewRequest("GET", ":bad", nil).Err == nil {
+		t.Error("bad url got no error")
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		default:
+			_, _ = fmt.Fprint(w, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	d := NewBaseDownloader()
+	resp, err := d.Do(Get(ts.URL + "/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Redirects) != 2 || resp.Redirects[0].StatusCode != 302 || resp.Redirects[1].To.Path != "/c" ||
+		resp.Redirects[1].From.Path != "/b" || resp.Request.URL.Path != "/c" {
+		t.Error("wrong redirects", resp.Redirects)
+	}
+	resp, err = d.Do(Get(ts.URL + "/a").SetNoRedirect(true))
+	if err != nil || resp.StatusCode != 302 || len(resp.Redirects) != 0 {
+		t.Error("redirect isn't disabled", err)
+	}
+	if _, err = d.Do(Get(ts.URL + "/a").SetMaxRedirects(1)); !errors.Is(err, ErrTooManyRedirects) {
+		t.Error("wrong error of max redirects", err)
+	}
+
+	var got []string
+	s := NewSpider(ReqDeduplicate(&Fingerprinter{FinalURL: true}))
+	s.OnRedirect(func(ctx *Context, hop *RedirectHop) bool {
+		return ctx.Req.URL.Path != "/b" || hop.To.Path != "/c"
+	})
+	s.AddTask(Get(ts.URL+"/b"), func(ctx *Context) {
+		got = append(got, ctx.Resp.Request.URL.Path+fmt.Sprint(ctx.Resp.StatusCode))
+		ctx.AddTask(Get(ts.URL+"/c"), func(ctx *Context) {
+			got = append(got, ctx.Resp.Text)
+			ctx.AddTask(Get(ts.URL+"/a"), func(ctx *Context) {
+				got = append(got, "/a crawled "+ctx.Resp.Text)
+			})
+		})
+	})
+	s.Run()
+	if strings.Join(got, ",") != "/b301,/c" {
+		t.Error("wrong OnRedirect or final url deduplicate", got)
+	}
+
+	// the headers set after the task is added don't change the fingerprint of final url
+	got = nil
+	s = NewSpider(RandomUserAgent(), ReqDeduplicate(&Fingerprinter{FinalURL: true}))
+	s.AddTask(Get(ts.URL+"/a"), func(ctx *Context) {
+		got = append(got, ctx.Resp.Text)
+		ctx.AddTask(Get(ts.URL+"/c"), func(ctx *Context) {
+			got = append(got, "/c crawled again")
+		})
+	})
+	s.Run()
+	if strings.Join(got, ",") != "/c" {
+		t.Error("final url isn't deduplicated with User-Agent set", got)
+	}
+}
@@ -0,0 +1,202 @@
+package goribot
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// BackendArgs is the args of the calls from RemoteBackend to ServeBackend
+type BackendArgs struct {
+	Key, Dst, Member string
+	Data             []byte
+	Time             time.Time
+	// Delete makes SetValue delete the key,gob doesn't tell nil from empty slice
+	Delete bool
+}
+
+// backendService exports a Backend by net/rpc
+type backendService struct {
+	b Backend
+}
+
+func (s *backendService) Push(a BackendArgs, _ *bool) error {
+	return s.b.Push(a.Key, a.Data)
+}
+
+func (s *backendService) Pop(a BackendArgs, res *[]byte) (err error) {
+	*res, err = s.b.Pop(a.Key)
+	return
+}
+
+func (s *backendService) Move(a BackendArgs, res *[]byte) (err error) {
+	*res, err = s.b.Move(a.Key, a.Dst)
+	return
+}
+
+func (s *backendService) Remove(a BackendArgs, _ *bool) error {
+	return s.b.Remove(a.Key, a.Data)
+}
+
+func (s *backendService) Len(a BackendArgs, res *int64) (err error) {
+	*res, err = s.b.Len(a.Key)
+	return
+}
+
+func (s *backendService) Heartbeat(a BackendArgs, _ *bool) error {
+	return s.b.Heartbeat(a.Key, a.Member, a.Time)
+}
+
+func (s *backendService) Members(a BackendArgs, res *map[string]time.Time) (err error) {
+	*res, err = s.b.Members(a.Key)
+	return
+}
+
+func (s *backendService) RemoveMember(a BackendArgs, _ *bool) error {
+	return s.b.RemoveMember(a.Key, a.Member)
+}
+
+func (s *backendService) SetValue(a BackendArgs, _ *bool) error {
+	if a.Delete {
+		return s.b.SetValue(a.Key, nil)
+	}
+	if a.Data == nil {
+		a.Data = []byte{}
+	}
+	return s.b.SetValue(a.Key, a.Data)
+}
+
+func (s *backendService) GetValue(a BackendArgs, res *[]byte) (err error) {
+	*res, err = s.b.GetValue(a.Key)
+	return
+}
+
+func (s *backendService) Add(a BackendArgs, res *bool) (err error) {
+	*res, err = s.b.Add(a.Key, a.Data)
+	return
+}
+
+func (s *backendService) Clear(a BackendArgs, _ *bool) error {
+	return s.b.Clear(a.Key)
+}
+
+// ServeBackend serves the Backend to RemoteBackend on the listener,so a process could act as the coordinator of
+// distributed mode without redis.It blocks until the listener is closed.
+// The rpc has no authentication,anyone could connect could read and change the data,so the listener must be on
+// localhost or a private network and never be exposed publicly.
+func ServeBackend(l net.Listener, b Backend) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Backend", &backendService{b}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// RemoteBackend is the Backend served by ServeBackend in another process
+type RemoteBackend struct {
+	addr   string
+	lock   sync.Mutex
+	client *rpc.Client
+}
+
+// DialBackend connects to the Backend served by ServeBackend at the tcp address
+func DialBackend(addr string) (*RemoteBackend, error) {
+	c, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteBackend{addr: addr, client: c}, nil
+}
+
+// call calls the method of backend,reconnecting if the connection is broken
+func (s *RemoteBackend) call(method string, args BackendArgs, reply interface{}) error {
+	s.lock.Lock()
+	c := s.client
+	s.lock.Unlock()
+	err := c.Call("Backend."+method, args, reply)
+	if !errors.Is(err, rpc.ErrShutdown) {
+		return err
+	}
+	s.lock.Lock()
+	if s.client == c {
+		n, e := rpc.Dial("tcp", s.addr)
+		if e != nil {
+			s.lock.Unlock()
+			return e
+		}
+		_ = c.Close()
+		s.client = n
+	}
+	c = s.client
+	s.lock.Unlock()
+	return c.Call("Backend."+method, args, reply)
+}
+
+// Close closes the connection
+func (s *RemoteBackend) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.client.Close()
+}
+
+func (s *RemoteBackend) Push(queue string, data []byte) error {
+	return s.call("Push", BackendArgs{Key: queue, Data: data}, new(bool))
+}
+
+func (s *RemoteBackend) Pop(queue string) (res []byte, err error) {
+	err = s.call("Pop", BackendArgs{Key: queue}, &res)
+	return
+}
+
+func (s *RemoteBackend) Move(src, dst string) (res []byte, err error) {
+	err = s.call("Move", BackendArgs{Key: src, Dst: dst}, &res)
+	return
+}
+
+func (s *RemoteBackend) Remove(queue string, data []byte) error {
+	return s.call("Remove", BackendArgs{Key: queue, Data: data}, new(bool))
+}
+
+func (s *RemoteBackend) Len(queue string) (res int64, err error) {
+	err = s.call("Len", BackendArgs{Key: queue}, &res)
+	return
+}
+
+func (s *RemoteBackend) Heartbeat(set, member string, t time.Time) error {
+	return s.call("Heartbeat", BackendArgs{Key: set, Member: member, Time: t}, new(bool))
+}
+
+func (s *RemoteBackend) Members(set string) (res map[string]time.Time, err error) {
+	err = s.call("Members", BackendArgs{Key: set}, &res)
+	return
+}
+
+func (s *RemoteBackend) RemoveMember(set, member string) error {
+	return s.call("RemoveMember", BackendArgs{Key: set, Member: member}, new(bool))
+}
+
+func (s *RemoteBackend) SetValue(key string, data []byte) error {
+	return s.call("SetValue", BackendArgs{Key: key, Data: data, Delete: data == nil}, new(bool))
+}
+
+func (s *RemoteBackend) GetValue(key string) (res []byte, err error) {
+	err = s.call("GetValue", BackendArgs{Key: key}, &res)
+	return
+}
+
+func (s *RemoteBackend) Add(set string, key []byte) (res bool, err error) {
+	err = s.call("Add", BackendArgs{Key: set, Data: key}, &res)
+	return
+}
+
+func (s *RemoteBackend) Clear(set string) error {
+	return s.call("Clear", BackendArgs{Key: set}, new(bool))
+}
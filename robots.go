@@ -0,0 +1,387 @@
+package goribot
+
+import (
+	"bytes"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/slyrz/robots"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRobotsDisallowed is the error of requests disallowed by robots.txt,see RobotsErr
+var ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// RobotsErr is set to Request.Err when the request is disallowed by robots.txt,so OnError gets it
+type RobotsErr struct {
+	URL       *url.URL
+	UserAgent string
+}
+
+func (e RobotsErr) Error() string {
+	return ErrRobotsDisallowed.Error() + ": " + e.URL.String()
+}
+
+// Unwrap returns ErrRobotsDisallowed,so errors.Is works with RobotsErr
+func (e RobotsErr) Unwrap() error {
+	return ErrRobotsDisallowed
+}
+
+// robotsMaxSize is the max size of robots.txt parsed,the rest is ignored as RFC 9309 allows
+const robotsMaxSize = 500 * 1024
+
+// RobotsOptions is the options of RobotsTxtManager,the zero fields use the default values
+type RobotsOptions struct {
+	// UserAgent is used to match the groups of robots.txt when the request has no User-Agent header,
+	// "Go-http-client" by default as net/http sends
+	UserAgent string
+	// TTL is how long a robots.txt is cached,24 hours by default
+	TTL time.Duration
+	// ErrorTTL is how long to wait before fetching again when the server is unreachable,1 minute by default
+	ErrorTTL time.Duration
+	// Limiter enforces the Crawl-delay.If it's nil,a Limiter is registered to the spider for it.
+	Limiter *LimiterController
+	// IgnoreCrawlDelay disables enforcing the Crawl-delay
+	IgnoreCrawlDelay bool
+	// MaxSites is the max number of sites cached,10000 by default.When it's reached,the expired sites are removed first,
+	// then the others at random,along with the Crawl-delay rules of their hosts.
+	MaxSites int
+}
+
+type robotsEntry struct {
+	// groups is nil if robots.txt is unavailable(allow all),disallowAll is set if it's unreachable
+	groups      robots.Groups
+	disallowAll bool
+	rules       map[string]*robots.Robots
+	expires     time.Time
+	// done is closed when the fetch finished,so concurrent requests to a site wait for the same one
+	done chan struct{}
+}
+
+// crawlDelay is the LimitRule enforcing Crawl-delay of a host
+type crawlDelay struct {
+	rule  *LimitRule
+	delay time.Duration
+}
+
+// RobotsManager fetches and caches robots.txt of each site,see RobotsTxtManager
+type RobotsManager struct {
+	opts    RobotsOptions
+	d       Downloader
+	lock    sync.Mutex
+	entries map[string]*robotsEntry
+	delays  map[string]*crawlDelay
+}
+
+// RobotsTxtManager is an extension follows robots.txt of each site(scheme and host).
+// robots.txt is fetched by the spider's Downloader when the site is first requested and cached for TTL.
+// As RFC 9309, all paths are allowed if robots.txt responds 4xx, and disallowed if it responds 5xx or fails,
+// in which case the last fetched robots.txt is used if any.
+// The groups are matched by the User-Agent of each request, and Crawl-delay is enforced through the Limiter.
+// The disallowed requests aren't sent,and a RobotsErr is sent to OnError.
+func RobotsTxtManager(opts RobotsOptions) (func(s *Spider), *RobotsManager) {
+	if opts.UserAgent == "" {
+		opts.UserAgent = "Go-http-client"
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.ErrorTTL <= 0 {
+		opts.ErrorTTL = time.Minute
+	}
+	if opts.MaxSites <= 0 {
+		opts.MaxSites = 10000
+	}
+	m := &RobotsManager{opts: opts, entries: map[string]*robotsEntry{}, delays: map[string]*crawlDelay{}}
+	return func(s *Spider) {
+		m.d = s.Downloader
+		if m.opts.Limiter == nil && !m.opts.IgnoreCrawlDelay {
+			var ext func(s *Spider)
+			ext, m.opts.Limiter = NewLimiter(false)
+			ext(s)
+		}
+		s.OnReq(func(ctx *Context, req *Request) *Request {
+			if req.Err == nil && !m.Allow(req) {
+				req.Err = RobotsErr{URL: req.URL, UserAgent: m.userAgent(req)}
+			}
+			return req
+		})
+	}, m
+}
+
+func (s *RobotsManager) userAgent(req *Request) string {
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		return ua
+	}
+	return s.opts.UserAgent
+}
+
+// Allow returns whether robots.txt allows the request,fetching robots.txt if it isn't cached
+func (s *RobotsManager) Allow(req *Request) bool {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return true
+	}
+	if req.URL.Path == "/robots.txt" {
+		return true
+	}
+	e := s.entry(req.URL)
+	if e.disallowAll {
+		return false
+	}
+	if e.groups == nil {
+		return true
+	}
+	ua := s.userAgent(req)
+	s.lock.Lock()
+	r, ok := e.rules[ua]
+	if !ok {
+		r = newRobots(e.groups, ua)
+		e.rules[ua] = r
+	}
+	s.lock.Unlock()
+	if !s.opts.IgnoreCrawlDelay && r.CrawlDelay > 0 {
+		s.setCrawlDelay(req.URL.Host, r.CrawlDelay)
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	return r.Allow(path)
+}
+
+// newRobots returns the rules of the group matching ua
+func newRobots(groups robots.Groups, ua string) *robots.Robots {
+	res := &robots.Robots{}
+	g := groups.Find(ua)
+	if g == nil {
+		return res
+	}
+	for _, v := range g.Allow {
+		if r := robots.NewRule(robots.TypeAllow, v); r.Length > 0 {
+			res.Rules = append(res.Rules, r)
+		}
+	}
+	for _, v := range g.Disallow {
+		if r := robots.NewRule(robots.TypeDisallow, v); r.Length > 0 {
+			res.Rules = append(res.Rules, r)
+		}
+	}
+	if g.CrawlDelay != "" {
+		if d, err := time.ParseDuration(strings.TrimSpace(g.CrawlDelay) + "s"); err == nil && d > 0 {
+			res.CrawlDelay = d
+		}
+	}
+	return res
+}
+
+// setCrawlDelay adds or updates the LimitRule of the host in Limiter
+func (s *RobotsManager) setCrawlDelay(host string, d time.Duration) {
+	host = strings.ToLower(host)
+	s.lock.Lock()
+	c, ok := s.delays[host]
+	if ok && c.delay == d {
+		s.lock.Unlock()
+		return
+	}
+	if !ok {
+		c = &crawlDelay{rule: &LimitRule{Regexp: "^" + regexp.QuoteMeta(host) + "$", Allow: NoFilter, Delay: d}}
+		s.delays[host] = c
+	}
+	c.delay = d
+	s.lock.Unlock()
+	if ok {
+		s.opts.Limiter.UpdateRule(c.rule, func(r *LimitRule) {
+			r.Delay = d
+		})
+	} else {
+		s.opts.Limiter.AddRule(c.rule)
+	}
+}
+
+// entry returns the cached robots.txt of the site,fetching it if it's missing or expired
+func (s *RobotsManager) entry(u *url.URL) *robotsEntry {
+	site := u.Scheme + "://" + strings.ToLower(u.Host)
+	s.lock.Lock()
+	old, ok := s.entries[site]
+	if ok {
+		select {
+		case <-old.done:
+			ok = time.Now().Before(old.expires)
+		default: // a fetch is running
+		}
+	}
+	if ok {
+		s.lock.Unlock()
+		<-old.done
+		return old
+	}
+	var evicted []*LimitRule
+	if old == nil && len(s.entries) >= s.opts.MaxSites {
+		evicted = s.evict()
+	}
+	e := &robotsEntry{done: make(chan struct{}), rules: map[string]*robots.Robots{}}
+	s.entries[site] = e
+	s.lock.Unlock()
+	for _, r := range evicted {
+		s.opts.Limiter.RemoveRule(r)
+	}
+
+	s.fetch(site, e, old)
+	close(e.done)
+	return e
+}
+
+// evict removes the expired sites,then the others until there are less than MaxSites,the fetching sites are kept.
+// It's called with lock held,and returns the Crawl-delay rules of the hosts no longer cached to be removed from Limiter.
+func (s *RobotsManager) evict() []*LimitRule {
+	now := time.Now()
+	hosts := map[string]struct{}{}
+	for _, expiredOnly := range []bool{true, false} {
+		for site, e := range s.entries {
+			if !expiredOnly && len(s.entries) < s.opts.MaxSites {
+				break
+			}
+			select {
+			case <-e.done:
+			default:
+				continue
+			}
+			if expiredOnly && now.Before(e.expires) {
+				continue
+			}
+			delete(s.entries, site)
+			hosts[site[strings.Index(site, "://")+3:]] = struct{}{}
+		}
+	}
+	var res []*LimitRule
+	for h := range hosts {
+		_, hasHTTP := s.entries["http://"+h]
+		_, hasHTTPS := s.entries["https://"+h]
+		if c, ok := s.delays[h]; ok && !hasHTTP && !hasHTTPS {
+			delete(s.delays, h)
+			res = append(res, c.rule)
+		}
+	}
+	return res
+}
+
+// fetch gets robots.txt of the site into e,old is the last cached one or nil
+func (s *RobotsManager) fetch(site string, e, old *robotsEntry) {
+	req := Get(site+"/robots.txt").SetHeader("User-Agent", s.opts.UserAgent)
+	var resp *Response
+	var err error
+	if req.Err == nil {
+		resp, err = s.d.Do(req)
+	} else {
+		err = req.Err
+	}
+	switch {
+	case err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body := resp.Body
+		if len(body) > robotsMaxSize {
+			body = body[:robotsMaxSize]
+		}
+		e.groups = robots.NewGroups(bytes.NewReader(body))
+		if e.groups == nil {
+			e.groups = robots.Groups{}
+		}
+		e.expires = time.Now().Add(s.opts.TTL)
+	case err == nil && resp.StatusCode >= 400 && resp.StatusCode < 500:
+		e.expires = time.Now().Add(s.opts.TTL)
+	default:
+		Log.Warning("get robots.txt of", site, "error", err, "disallow the site for", s.opts.ErrorTTL)
+		if old != nil && old.groups != nil {
+			e.groups = old.groups
+		} else {
+			e.disallowAll = true
+		}
+		e.expires = time.Now().Add(s.opts.ErrorTTL)
+	}
+}
+
+// Clear removes all the cached robots.txt
+func (s *RobotsManager) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = map[string]*robotsEntry{}
+}
+
+// RobotsDirectives are the robots directives of a page
+type RobotsDirectives struct {
+	// NoIndex means the page shouldn't be indexed,i.e. no item should be extracted from it
+	NoIndex bool
+	// NoFollow means the links of the page shouldn't be followed
+	NoFollow bool
+}
+
+func (s *RobotsDirectives) parse(content string) {
+	for _, i := range strings.Split(strings.ToLower(content), ",") {
+		switch strings.TrimSpace(i) {
+		case "noindex":
+			s.NoIndex = true
+		case "nofollow":
+			s.NoFollow = true
+		case "none":
+			s.NoIndex, s.NoFollow = true, true
+		}
+	}
+}
+
+// RobotsDirectives returns the directives from <meta name="robots"> and the X-Robots-Tag headers.
+// names are the crawler names also matched,e.g. "goribot" matches <meta name="goribot"> and "X-Robots-Tag: goribot: noindex".
+func (s *Response) RobotsDirectives(names ...string) RobotsDirectives {
+	var res RobotsDirectives
+	match := func(name string) bool {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for _, n := range names {
+			if strings.ToLower(n) == name {
+				return true
+			}
+		}
+		return false
+	}
+	if s.Dom != nil {
+		s.Dom.Find("meta[name][content]").Each(func(i int, sel *goquery.Selection) {
+			if name := sel.AttrOr("name", ""); strings.EqualFold(name, "robots") || match(name) {
+				res.parse(sel.AttrOr("content", ""))
+			}
+		})
+	}
+	if s.Response == nil {
+		return res
+	}
+	for _, h := range s.Header.Values("X-Robots-Tag") {
+		// the header could be prefixed by a crawler name like "goribot: noindex",
+		// the unavailable_after directive contains colon as well
+		if k := strings.Index(h, ":"); k >= 0 && !strings.Contains(h[:k], ",") && !strings.EqualFold(strings.TrimSpace(h[:k]), "unavailable_after") {
+			if !match(h[:k]) {
+				continue
+			}
+			h = h[k+1:]
+		}
+		res.parse(h)
+	}
+	return res
+}
+
+// MetaRobots is an extension follows the robots directives of pages,see Response.RobotsDirectives.
+// The items of noindex pages and the new tasks of nofollow pages are dropped,see Context.NoIndex and Context.NoFollow.
+func MetaRobots(names ...string) func(s *Spider) {
+	return func(s *Spider) {
+		s.OnResp(func(ctx *Context) {
+			d := ctx.Resp.RobotsDirectives(names...)
+			if d.NoIndex {
+				ctx.NoIndex()
+			}
+			if d.NoFollow {
+				ctx.NoFollow()
+			}
+		})
+	}
+}
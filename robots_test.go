@@ -0,0 +1,233 @@
+package goribot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRobotsTxtManager(t *testing.T) {
+	var fetched int32
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&fetched, 1)
+			_, _ = fmt.Fprint(w, "User-agent: *\nDisallow: /private\n\nUser-agent: goribot\nDisallow: /secret\nCrawl-delay: 0.2\n")
+			return
+		}
+		got = append(got, r.UserAgent()+" "+r.URL.Path)
+	}))
+	defer ts.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		t.Error("site should be disallowed when robots.txt is unreachable")
+	}))
+	defer down.Close()
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		got = append(got, "missing "+r.URL.Path)
+	}))
+	defer missing.Close()
+
+	ext, m := RobotsTxtManager(RobotsOptions{UserAgent: "other"})
+	s := NewSpider(ext)
+	var errs int32
+	s.OnError(func(ctx *Context, err error) {
+		if errors.Is(err, ErrRobotsDisallowed) {
+			atomic.AddInt32(&errs, 1)
+		}
+	})
+	s.AddTask(Get(ts.URL + "/private"))
+	s.AddTask(Get(ts.URL + "/secret"))
+	s.AddTask(Get(ts.URL+"/secret").SetHeader("User-Agent", "Goribot/1.0"))
+	s.AddTask(Get(ts.URL+"/private").SetHeader("User-Agent", "Goribot/1.0"))
+	s.AddTask(Get(ts.URL+"/ok").SetHeader("User-Agent", "Goribot/1.0"))
+	s.AddTask(Get(down.URL + "/a"))
+	s.AddTask(Get(missing.URL + "/a"))
+	s.Run()
+
+	want := map[string]bool{"Go-http-client/1.1 /secret": true, "Goribot/1.0 /private": true, "Goribot/1.0 /ok": true, "missing /a": true}
+	if len(got) != len(want) {
+		t.Error("wrong requests", got)
+	}
+	for _, i := range got {
+		if !want[i] {
+			t.Error("wrong request", i)
+		}
+	}
+	if errs != 3 || fetched != 1 {
+		t.Error("wrong errors or fetches", errs, fetched)
+	}
+	rules := m.opts.Limiter.Rules()
+	if len(rules) != 1 || rules[0].Delay != 200*time.Millisecond || !rules[0].Match(Get(ts.URL).URL) {
+		t.Error("Crawl-delay isn't enforced", rules)
+	}
+	if !strings.HasPrefix(RobotsErr{URL: Get(ts.URL).URL}.Error(), ErrRobotsDisallowed.Error()) {
+		t.Error("wrong error message")
+	}
+}
+
+func TestMetaRobots(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Path)
+		switch r.URL.Path {
+		case "/nofollow":
+			_, _ = fmt.Fprint(w, `<html><head><meta name="robots" content="NoFollow"></head><a href="/a">a</a></html>`)
+		case "/noindex":
+			w.Header().Set("X-Robots-Tag", "goribot: noindex")
+			w.Header().Add("X-Robots-Tag", "otherbot: nofollow")
+			_, _ = fmt.Fprint(w, `<html><a href="/b">b</a></html>`)
+		case "/none":
+			_, _ = fmt.Fprint(w, `<html><head><meta name="Goribot" content="none"></head><a href="/c">c</a></html>`)
+		}
+	}))
+	defer ts.Close()
+	var items []interface{}
+	s := NewSpider(MetaRobots("goribot"))
+	s.OnHTML("a[href]", func(ctx *Context, sel *goquery.Selection) {
+		ctx.AddTask(Get(sel.AttrOr("href", "")))
+		ctx.AddItem(ctx.Req.URL.Path)
+	})
+	s.OnItem(func(i interface{}) interface{} {
+		items = append(items, i)
+		return i
+	})
+	s.AddTask(Get(ts.URL + "/nofollow"))
+	s.AddTask(Get(ts.URL + "/noindex"))
+	s.AddTask(Get(ts.URL + "/none"))
+	s.Run()
+	sort.Strings(got)
+	if strings.Join(got, ",") != "/b,/nofollow,/noindex,/none" {
+		t.Error("wrong requests", got)
+	}
+	if len(items) != 1 || items[0] != "/nofollow" {
+		t.Error("wrong items", items)
+	}
+}
+
+func TestCanonicalDeduplicate(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.String())
+		switch r.URL.Path {
+		case "/page":
+			_, _ = fmt.Fprint(w, `<html><head><link rel="canonical" href="/article"></head></html>`)
+		case "/amp":
+			w.Header().Set("Link", `</article>; rel="canonical"`)
+		}
+	}))
+	defer ts.Close()
+	var handled []string
+	s := NewSpider(ReqDeduplicate(&Fingerprinter{Canonical: true}))
+	s.AddTask(Get(ts.URL+"/page"), func(ctx *Context) {
+		handled = append(handled, ctx.Req.URL.Path)
+		for _, u := range []string{"/article", "/amp"} {
+			ctx.AddTask(Get(u), func(ctx *Context) {
+				handled = append(handled, ctx.Req.URL.Path)
+			})
+		}
+	})
+	s.Run()
+	if strings.Join(got, ",") != "/page,/amp" || strings.Join(handled, ",") != "/page" {
+		t.Error("canonical isn't deduplicated", got, handled)
+	}
+
+	// the headers set after the task is added don't change the fingerprint of canonical url
+	got = nil
+	s = NewSpider(RandomUserAgent(), ReqDeduplicate(&Fingerprinter{Canonical: true}))
+	s.AddTask(Get(ts.URL+"/page"), func(ctx *Context) {
+		ctx.AddTask(Get(ts.URL + "/article"))
+	})
+	s.Run()
+	if strings.Join(got, ",") != "/page" {
+		t.Error("canonical isn't deduplicated with User-Agent set", got)
+	}
+}
+
+func TestRobotsCrawlDelayWhiteList(t *testing.T) {
+	var got []string
+	lock := sync.Mutex{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			_, _ = fmt.Fprint(w, "User-agent: *\nCrawl-delay: 0.1\n")
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		got = append(got, r.URL.Path)
+	}))
+	defer ts.Close()
+	limiter, ctrl := NewLimiter(true, &LimitRule{Regexp: "^/a$", MatchOn: MatchPath})
+	robots, _ := RobotsTxtManager(RobotsOptions{Limiter: ctrl})
+	s := NewSpider(limiter, robots)
+	s.AddTask(Get(ts.URL+"/a"), func(ctx *Context) {
+		ctx.AddTask(Get(ts.URL + "/b"))
+	})
+	s.Run()
+	if len(got) != 1 || got[0] != "/a" {
+		t.Error("Crawl-delay rule allows the site in the white list", got)
+	}
+	rules := ctrl.Rules()
+	if len(rules) != 2 || rules[1].Delay != 100*time.Millisecond || rules[1].Allow != NoFilter {
+		t.Error("Crawl-delay isn't enforced", rules)
+	}
+}
+
+func TestRobotsMaxSites(t *testing.T) {
+	var sites []*httptest.Server
+	for i := 0; i < 3; i++ {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, "User-agent: *\nCrawl-delay: 0.01\n")
+		}))
+		defer ts.Close()
+		sites = append(sites, ts)
+	}
+	_, ctrl := NewLimiter(false)
+	ext, m := RobotsTxtManager(RobotsOptions{TTL: 50 * time.Millisecond, MaxSites: 2, Limiter: ctrl})
+	NewSpider(ext)
+	cached := func() []string {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		var res []string
+		for k := range m.entries {
+			res = append(res, k)
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	// the expired site is removed first
+	m.Allow(Get(sites[0].URL + "/a"))
+	time.Sleep(60 * time.Millisecond)
+	m.Allow(Get(sites[1].URL + "/a"))
+	m.Allow(Get(sites[2].URL + "/a"))
+	want := []string{sites[1].URL, sites[2].URL}
+	sort.Strings(want)
+	if got := cached(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Error("expired site isn't removed", got)
+	}
+	if len(ctrl.Rules()) != 2 || len(m.delays) != 2 {
+		t.Error("Crawl-delay rule of removed site is kept", ctrl.Rules())
+	}
+
+	// the sites are capped even if none expires
+	m.Allow(Get(sites[0].URL + "/a"))
+	if got := cached(); len(got) != 2 || len(ctrl.Rules()) != 2 {
+		t.Error("too many sites are cached", got, ctrl.Rules())
+	}
+}
@@ -22,6 +22,22 @@ type Scheduler interface {
 	IsItemEmpty() bool
 }
 
+// AckScheduler is a Scheduler needs to know when a task is done,e.g. to remove it from a persistent queue.
+// The spider calls AckTask after the handlers of task finished and its new tasks and items are added.
+type AckScheduler interface {
+	Scheduler
+	// AckTask marks the task got from GetTask as done
+	AckTask(t *Task)
+}
+
+// ItemErrScheduler is a Scheduler whose AddItem could fail,e.g. when the item can't be sent to a remote queue.
+// The spider calls TryAddItem instead of AddItem,and sends the error to OnError with the context of the item.
+type ItemErrScheduler interface {
+	Scheduler
+	// TryAddItem pushes a item,returns the error if it fails
+	TryAddItem(i interface{}) error
+}
+
 // Scheduler is default scheduler of goribot
 type BaseScheduler struct {
 	tasksLock sync.Mutex
@@ -37,24 +53,24 @@ func NewBaseScheduler(depthFirst bool) *BaseScheduler {
 }
 
 func (s *BaseScheduler) GetTask() *Task {
+	s.tasksLock.Lock()
+	defer s.tasksLock.Unlock()
 	if len(s.tasks) == 0 {
 		return nil
 	}
-	s.tasksLock.Lock()
 	task := s.tasks[0]
 	s.tasks = s.tasks[1:]
-	s.tasksLock.Unlock()
 	return task
 
 }
 func (s *BaseScheduler) GetItem() interface{} {
+	s.itemsLock.Lock()
+	defer s.itemsLock.Unlock()
 	if len(s.items) == 0 {
 		return nil
 	}
-	s.itemsLock.Lock()
 	item := s.items[0]
 	s.items = s.items[1:]
-	s.itemsLock.Unlock()
 	return item
 }
 func (s *BaseScheduler) AddTask(t *Task) {
@@ -73,8 +89,17 @@ func (s *BaseScheduler) AddItem(i interface{}) {
 
 }
 func (s *BaseScheduler) IsTaskEmpty() bool {
-	return len(s.tasks) == 0
+	return s.Len() == 0
 }
 func (s *BaseScheduler) IsItemEmpty() bool {
+	s.itemsLock.Lock()
+	defer s.itemsLock.Unlock()
 	return len(s.items) == 0
 }
+
+// Len returns the number of queued tasks
+func (s *BaseScheduler) Len() int {
+	s.tasksLock.Lock()
+	defer s.tasksLock.Unlock()
+	return len(s.tasks)
+}
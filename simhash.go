@@ -0,0 +1,198 @@
+package goribot
+
+import (
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// simHashShingle is the number of tokens in a feature of SimHash
+const simHashShingle = 3
+
+// tokenize splits text to words,each CJK character is a single word
+func tokenize(text string) []string {
+	var res []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			res = append(res, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			res = append(res, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return res
+}
+
+// SimHash returns the 64 bits simhash of text,similar texts have hashes with small hamming distance.
+// It returns 0 if the text contains no word.
+func SimHash(text string) uint64 {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return 0
+	}
+	n := simHashShingle
+	if len(tokens) < n {
+		n = len(tokens)
+	}
+	var v [64]int
+	h := fnv.New64a()
+	for i := 0; i+n <= len(tokens); i++ {
+		h.Reset()
+		_, _ = h.Write([]byte(strings.Join(tokens[i:i+n], " ")))
+		sum := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if sum&(1<<uint(b)) != 0 {
+				v[b] += 1
+			} else {
+				v[b] -= 1
+			}
+		}
+	}
+	var res uint64
+	for b := 0; b < 64; b++ {
+		if v[b] > 0 {
+			res |= 1 << uint(b)
+		}
+	}
+	return res
+}
+
+// HammingDistance returns the number of different bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// ErrBadSimHashDistance is the panic of creating a SimHashIndex with maxDistance out of [0,63]
+var ErrBadSimHashDistance = errors.New("bad simhash distance,maxDistance must be in [0,63]")
+
+type simHashEntry struct {
+	hash uint64
+	id   string
+}
+
+// SimHashIndex finds the hashes within a hamming distance quickly.
+// The hash is split into maxDistance+1 blocks,two hashes within the distance must share a same block.
+type SimHashIndex struct {
+	lock        sync.RWMutex
+	maxDistance int
+	tables      []map[uint64][]simHashEntry
+}
+
+// NewSimHashIndex creates a SimHashIndex.
+// It panics with ErrBadSimHashDistance if maxDistance isn't in [0,63].
+func NewSimHashIndex(maxDistance int) *SimHashIndex {
+	if maxDistance < 0 || maxDistance >= 64 {
+		panic(ErrBadSimHashDistance)
+	}
+	s := &SimHashIndex{maxDistance: maxDistance}
+	for i := 0; i <= maxDistance; i++ {
+		s.tables = append(s.tables, map[uint64][]simHashEntry{})
+	}
+	return s
+}
+
+// block returns the i-th block of hash
+func (s *SimHashIndex) block(hash uint64, i int) uint64 {
+	size := 64 / len(s.tables)
+	start := uint(i * size)
+	if i == len(s.tables)-1 {
+		return hash >> start
+	}
+	return (hash >> start) & (1<<uint(size) - 1)
+}
+
+// Add adds a hash with its id,e.g. the url of page
+func (s *SimHashIndex) Add(hash uint64, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.add(hash, id)
+}
+
+func (s *SimHashIndex) add(hash uint64, id string) {
+	for i, t := range s.tables {
+		b := s.block(hash, i)
+		t[b] = append(t[b], simHashEntry{hash, id})
+	}
+}
+
+// Query returns the id of the nearest hash within the max distance
+func (s *SimHashIndex) Query(hash uint64) (id string, distance int, ok bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.query(hash)
+}
+
+// QueryOrAdd works like Query,and adds the hash if there is no near one
+func (s *SimHashIndex) QueryOrAdd(hash uint64, id string) (dup string, distance int, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if dup, distance, ok = s.query(hash); !ok {
+		s.add(hash, id)
+	}
+	return
+}
+
+func (s *SimHashIndex) query(hash uint64) (id string, distance int, ok bool) {
+	distance = s.maxDistance + 1
+	for i, t := range s.tables {
+		for _, e := range t[s.block(hash, i)] {
+			if d := HammingDistance(hash, e.hash); d < distance {
+				id, distance, ok = e.id, d, true
+			}
+		}
+	}
+	return
+}
+
+// DomText returns the visible text of a html document,without script and style
+func DomText(dom *goquery.Document) string {
+	body := dom.Find("body")
+	if body.Length() == 0 {
+		body = dom.Selection
+	}
+	body = body.Clone()
+	body.Find("script, style, noscript, template").Remove()
+	return body.Text()
+}
+
+// ContentDeduplicate is an extension detects the html responses with near-duplicate content by SimHash.
+// The hash of page is set to ctx.Meta["SimHash"], and if the page is within maxDistance of a crawled one,
+// the url of that page is set to ctx.Meta["SimHashDuplicateOf"] and the ctx is aborted if drop is true.
+// It panics with ErrBadSimHashDistance if maxDistance isn't in [0,63].
+func ContentDeduplicate(maxDistance int, drop bool) func(s *Spider) {
+	index := NewSimHashIndex(maxDistance)
+	return func(s *Spider) {
+		s.OnResp(func(ctx *Context) {
+			if ctx.Resp.Dom == nil || ctx.Resp.StatusCode < 200 || ctx.Resp.StatusCode >= 300 {
+				return
+			}
+			hash := SimHash(DomText(ctx.Resp.Dom))
+			if hash == 0 {
+				return
+			}
+			ctx.Meta["SimHash"] = hash
+			u := ctx.Resp.Request.URL.String()
+			if dup, _, ok := index.QueryOrAdd(hash, u); ok && dup != u {
+				ctx.Meta["SimHashDuplicateOf"] = dup
+				if drop {
+					ctx.Abort()
+				}
+			}
+		})
+	}
+}
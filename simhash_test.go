@@ -0,0 +1,88 @@
+package goribot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const simHashTestArticle = `Goribot is a distributed crawler framework written in golang. It is easy to use,
+provides many extensions such as limiter, retry, robots.txt support, deduplicate and so on.
+You can write a spider in a few lines and run it on many machines with redis.`
+
+func TestSimHash(t *testing.T) {
+	a := SimHash(simHashTestArticle)
+	b := SimHash(simHashTestArticle + " Copyright 2020.")
+	c := SimHash("一个完全不同的页面，讲述的是今天的天气情况，以及明天可能会下雨的消息。")
+	if d := HammingDistance(a, b); d > 10 {
+		t.Error("similar texts got large distance", d)
+	}
+	if d := HammingDistance(a, c); d <= 10 {
+		t.Error("different texts got small distance", d)
+	}
+
+	index := NewSimHashIndex(10)
+	index.Add(a, "a")
+	if id, _, ok := index.Query(b); !ok || id != "a" {
+		t.Error("near hash not found")
+	}
+	if _, _, ok := index.QueryOrAdd(c, "c"); ok {
+		t.Error("far hash found")
+	}
+	if id, d, ok := index.Query(c); !ok || id != "c" || d != 0 {
+		t.Error("hash not added")
+	}
+
+	index = NewSimHashIndex(63)
+	index.Add(a, "a")
+	if id, _, ok := index.Query(b); !ok || id != "a" {
+		t.Error("near hash not found with max distance")
+	}
+	for _, d := range []int{-1, 64} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrBadSimHashDistance {
+					t.Error("bad distance should panic", d, r)
+				}
+			}()
+			NewSimHashIndex(d)
+		}()
+	}
+}
+
+func TestContentDeduplicate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if strings.HasPrefix(r.URL.Path, "/article") {
+			_, _ = fmt.Fprintf(w, "<html><body><p>%s</p><script>var t = '%s';</script></body></html>", simHashTestArticle, r.URL.Path)
+		} else {
+			_, _ = fmt.Fprint(w, "<html><body><p>Another page about the weather of today and tomorrow.</p></body></html>")
+		}
+	}))
+	defer ts.Close()
+
+	lock := sync.Mutex{}
+	got := map[string]interface{}{}
+	s := NewSpider(ContentDeduplicate(3, false))
+	s.SetTaskPoolSize(1)
+	for _, p := range []string{"/article", "/article?from=home", "/other"} {
+		s.AddTask(GetReq(ts.URL+p), func(ctx *Context) {
+			lock.Lock()
+			defer lock.Unlock()
+			if _, ok := ctx.Meta["SimHash"].(uint64); !ok {
+				t.Error("missing SimHash")
+			}
+			got[ctx.Req.URL.RequestURI()] = ctx.Meta["SimHashDuplicateOf"]
+		})
+	}
+	s.Run()
+	if got["/article"] != nil || got["/other"] != nil {
+		t.Error("wrong duplicate flag", got)
+	}
+	if got["/article?from=home"] != ts.URL+"/article" {
+		t.Error("duplicate not flagged", got)
+	}
+}
@@ -0,0 +1,180 @@
+package goribot
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DownloaderOption is an option of BaseDownloader,see NewBaseDownloader
+type DownloaderOption func(d *BaseDownloader)
+
+// newTransport creates the transport shared by all requests of a BaseDownloader
+func (s *BaseDownloader) newTransport() {
+	s.dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	s.transport = &http.Transport{
+		Proxy:                 requestProxy,
+		DialContext:           s.dialContext,
+		TLSClientConfig:       &tls.Config{},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// requestProxy returns Request.ProxyURL if set,otherwise the proxy from environment
+func requestProxy(r *http.Request) (*url.URL, error) {
+	if state, ok := r.Context().Value(downloadStateKey{}).(*downloadState); ok && state.req.ProxyURL != "" {
+		return url.Parse(state.req.ProxyURL)
+	}
+	return http.ProxyFromEnvironment(r)
+}
+
+// Apply applies the options to the downloader,it should be called before downloading
+func (s *BaseDownloader) Apply(opts ...DownloaderOption) {
+	for _, o := range opts {
+		o(s)
+	}
+}
+
+// Transport returns the transport of downloader,which is shared by all requests
+func (s *BaseDownloader) Transport() *http.Transport {
+	return s.transport
+}
+
+// WithInsecureSkipVerify skips verifying the certificates of the hosts,or all hosts if no host is given.
+// The per host setting doesn't work with proxy.
+func WithInsecureSkipVerify(hosts ...string) DownloaderOption {
+	return func(d *BaseDownloader) {
+		if len(hosts) == 0 {
+			d.transport.TLSClientConfig.InsecureSkipVerify = true
+			return
+		}
+		if d.skipVerifyHosts == nil {
+			d.skipVerifyHosts = map[string]struct{}{}
+			d.transport.DialTLSContext = d.dialTLS
+		}
+		for _, h := range hosts {
+			d.skipVerifyHosts[strings.ToLower(h)] = struct{}{}
+		}
+	}
+}
+
+// dialTLS dials a tls connection,skipping verify if the host is in skipVerifyHosts
+func (s *BaseDownloader) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := s.dialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	c := s.transport.TLSClientConfig.Clone()
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+	if _, ok := s.skipVerifyHosts[strings.ToLower(host)]; ok {
+		c.InsecureSkipVerify = true
+	}
+	if t := s.transport.TLSHandshakeTimeout; t > 0 {
+		_ = conn.SetDeadline(time.Now().Add(t))
+	}
+	tc := tls.Client(conn, c)
+	if err = tc.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// WithRootCAs sets the CA pool used to verify the certificates of servers
+func WithRootCAs(pool *x509.CertPool) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.TLSClientConfig.RootCAs = pool
+	}
+}
+
+// WithClientCert adds the client certificates sent to the servers requiring them
+func WithClientCert(certs ...tls.Certificate) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.TLSClientConfig.Certificates = append(d.transport.TLSClientConfig.Certificates, certs...)
+	}
+}
+
+// WithHTTP2 enables or disables HTTP/2,which is enabled by default
+func WithHTTP2(enabled bool) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.ForceAttemptHTTP2 = enabled
+		if enabled {
+			d.transport.TLSNextProto = nil
+		} else {
+			d.transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}
+}
+
+// WithMaxIdleConns sets the max number of idle connections across all hosts,0 means no limit
+func WithMaxIdleConns(n int) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.MaxIdleConns = n
+	}
+}
+
+// WithMaxIdleConnsPerHost sets the max number of idle connections to keep per host
+func WithMaxIdleConnsPerHost(n int) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.MaxIdleConnsPerHost = n
+	}
+}
+
+// WithMaxConnsPerHost sets the max number of connections per host,0 means no limit
+func WithMaxConnsPerHost(n int) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.MaxConnsPerHost = n
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept
+func WithIdleConnTimeout(t time.Duration) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.transport.IdleConnTimeout = t
+	}
+}
+
+// WithKeepAlive sets the period of tcp keep-alive,a negative value disables keep-alive and connection reuse
+func WithKeepAlive(t time.Duration) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.dialer.KeepAlive = t
+		d.transport.DisableKeepAlives = t < 0
+	}
+}
+
+// WithDialTimeout sets the timeout of establishing connections
+func WithDialTimeout(t time.Duration) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.dialer.Timeout = t
+	}
+}
+
+// WithTimeout sets the timeout of whole request,including redirects and reading body
+func WithTimeout(t time.Duration) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.Client.Timeout = t
+	}
+}
+
+// WithLocalAddr binds the connections to the local ip,which is useful on the machines with multiple ips
+func WithLocalAddr(ip net.IP) DownloaderOption {
+	return func(d *BaseDownloader) {
+		d.dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+}
@@ -0,0 +1,100 @@
+package goribot
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTLSTestServer starts a https server with http2,which responds the protocol and the number of client certs
+func newTLSTestServer(clientAuth tls.ClientAuthType) (*httptest.Server, *x509.CertPool) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprint(w, r.Proto, " ", len(r.TLS.PeerCertificates))
+	}))
+	ts.EnableHTTP2 = true
+	ts.TLS = &tls.Config{ClientAuth: clientAuth}
+	ts.StartTLS()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	return ts, pool
+}
+
+func TestTLSOptions(t *testing.T) {
+	ts, _ := newTLSTestServer(tls.NoClientCert)
+	defer ts.Close()
+	if _, err := NewBaseDownloader().Do(Get(ts.URL)); err == nil {
+		t.Error("self-signed cert is accepted")
+	}
+	if _, err := NewBaseDownloader(WithInsecureSkipVerify("example.com")).Do(Get(ts.URL)); err == nil {
+		t.Error("cert of other host is skipped")
+	}
+
+	for _, c := range []struct {
+		name       string
+		clientAuth tls.ClientAuthType
+		opts       func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption
+		want       string
+	}{
+		{"skip verify host", tls.NoClientCert, func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption {
+			return []DownloaderOption{WithInsecureSkipVerify("127.0.0.1")}
+		}, "HTTP/2.0 0"},
+		{"skip verify all", tls.NoClientCert, func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption {
+			return []DownloaderOption{WithInsecureSkipVerify()}
+		}, "HTTP/2.0 0"},
+		{"root ca", tls.NoClientCert, func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption {
+			return []DownloaderOption{WithRootCAs(pool)}
+		}, "HTTP/2.0 0"},
+		{"no http2", tls.NoClientCert, func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption {
+			return []DownloaderOption{WithRootCAs(pool), WithHTTP2(false)}
+		}, "HTTP/1.1 0"},
+		{"client cert", tls.RequestClientCert, func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption {
+			return []DownloaderOption{WithRootCAs(pool), WithClientCert(ts.TLS.Certificates[0])}
+		}, "HTTP/2.0 1"},
+		{"local addr", tls.NoClientCert, func(ts *httptest.Server, pool *x509.CertPool) []DownloaderOption {
+			return []DownloaderOption{WithRootCAs(pool), WithLocalAddr(net.ParseIP("127.0.0.1")), WithKeepAlive(-1)}
+		}, "HTTP/2.0 0"},
+	} {
+		func() {
+			ts, pool := newTLSTestServer(c.clientAuth)
+			defer ts.Close()
+			resp, err := NewBaseDownloader(c.opts(ts, pool)...).Do(Get(ts.URL))
+			if err != nil {
+				t.Error(c.name, err)
+				return
+			}
+			if resp.Text != c.want {
+				t.Error(c.name, "got", resp.Text, "want", c.want)
+			}
+		}()
+	}
+}
+
+func TestRequestProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "direct")
+	}))
+	defer target.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "proxied ", r.URL.String())
+	}))
+	defer proxy.Close()
+
+	d := NewBaseDownloader()
+	tr := d.Client.Transport
+	resp, err := d.Do(Get(target.URL + "/a").SetProxy(proxy.URL))
+	if err != nil || resp.Text != "proxied "+target.URL+"/a" {
+		t.Error("request isn't proxied", err)
+	}
+	resp, err = d.Do(Get(target.URL))
+	if err != nil || resp.Text != "direct" {
+		t.Error("proxy is kept for other requests", err)
+	}
+	if d.Client.Transport != tr {
+		t.Error("transport is replaced")
+	}
+}
@@ -0,0 +1,242 @@
+package goribot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// WireVersion is the version of wire format of tasks and items in distributed mode
+const WireVersion = 1
+
+// ErrUnregisteredType is returned when encoding a value whose type isn't registered by RegisterType
+var ErrUnregisteredType = errors.New("type isn't registered")
+
+// DecodeErr is a error occurred when decoding the tasks or items from distributed backend
+type DecodeErr struct {
+	error
+	// Data is the raw data decoded
+	Data []byte
+}
+
+// Unwrap returns the underlying error,so errors.Is works with DecodeErr
+func (e DecodeErr) Unwrap() error {
+	return e.error
+}
+
+// EncodeErr is a error occurred when encoding the items sent to distributed backend
+type EncodeErr struct {
+	error
+	// Item is the item encoded
+	Item interface{}
+}
+
+// Unwrap returns the underlying error,so errors.Is works with EncodeErr
+func (e EncodeErr) Unwrap() error {
+	return e.error
+}
+
+var (
+	typesLock  sync.RWMutex
+	typeByName = map[string]reflect.Type{}
+	nameByType = map[reflect.Type]string{}
+
+	handlersLock sync.RWMutex
+	handlers     = map[string]CtxHandlerFun{}
+)
+
+func init() {
+	for name, v := range map[string]interface{}{
+		"string":                 "",
+		"bool":                   false,
+		"int":                    0,
+		"int64":                  int64(0),
+		"uint64":                 uint64(0),
+		"float64":                float64(0),
+		"[]byte":                 []byte{},
+		"[]string":               []string{},
+		"[]interface{}":          []interface{}{},
+		"map[string]string":      map[string]string{},
+		"map[string]interface{}": map[string]interface{}{},
+		"http.Header":            http.Header{},
+		"struct{}":               struct{}{},
+	} {
+		RegisterType(name, v)
+	}
+}
+
+// RegisterType registers the type of v by name,so the values of this type in Request.Meta and items could be sent
+// between the manager and workers.The name must be the same among them.
+// The builtin types like string, int, float64, bool, []string and map[string]interface{} are registered by default.
+func RegisterType(name string, v interface{}) {
+	typesLock.Lock()
+	defer typesLock.Unlock()
+	t := reflect.TypeOf(v)
+	typeByName[name] = t
+	nameByType[t] = name
+}
+
+// RegisterHandler registers the handler by name,so the tasks sent by Manager.SendReq could be handled by it
+func RegisterHandler(name string, fn CtxHandlerFun) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[name] = fn
+}
+
+// wireValue is a value with its registered type name
+type wireValue struct {
+	Type string          `json:"type,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func encodeValue(v interface{}) (wireValue, error) {
+	if v == nil {
+		return wireValue{}, nil
+	}
+	typesLock.RLock()
+	name, ok := nameByType[reflect.TypeOf(v)]
+	typesLock.RUnlock()
+	if !ok {
+		return wireValue{}, fmt.Errorf("%w: %T", ErrUnregisteredType, v)
+	}
+	data, err := json.Marshal(v)
+	return wireValue{Type: name, Data: data}, err
+}
+
+func decodeValue(w wireValue) (interface{}, error) {
+	if w.Type == "" {
+		return nil, nil
+	}
+	typesLock.RLock()
+	t, ok := typeByName[w.Type]
+	typesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregisteredType, w.Type)
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(w.Data, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// wireRequest is the wire format of Request
+type wireRequest struct {
+	Version                   int                  `json:"v"`
+	Method                    string               `json:"method"`
+	URL                       string               `json:"url"`
+	Header                    http.Header          `json:"header,omitempty"`
+	Body                      []byte               `json:"body,omitempty"`
+	Meta                      map[string]wireValue `json:"meta,omitempty"`
+	Depth                     int                  `json:"depth"`
+	ProxyURL                  string               `json:"proxy,omitempty"`
+	ResponseCharacterEncoding string               `json:"encoding,omitempty"`
+	MaxRedirects              int                  `json:"max_redirects,omitempty"`
+	NoRedirect                bool                 `json:"no_redirect,omitempty"`
+	Handlers                  []string             `json:"handlers,omitempty"`
+}
+
+// wireItem is the wire format of item
+type wireItem struct {
+	Version int       `json:"v"`
+	Item    wireValue `json:"item"`
+}
+
+// EncodeRequest encodes the request and the names of its handlers registered by RegisterHandler to the wire format
+func EncodeRequest(req *Request, handlerNames ...string) ([]byte, error) {
+	if req.Err != nil {
+		return nil, req.Err
+	}
+	w := wireRequest{
+		Version:                   WireVersion,
+		Method:                    req.Method,
+		URL:                       req.URL.String(),
+		Header:                    req.Header,
+		Body:                      req.GetBody(),
+		Meta:                      map[string]wireValue{},
+		Depth:                     req.Depth,
+		ProxyURL:                  req.ProxyURL,
+		ResponseCharacterEncoding: req.ResponseCharacterEncoding,
+		MaxRedirects:              req.MaxRedirects,
+		NoRedirect:                req.NoRedirect,
+		Handlers:                  handlerNames,
+	}
+	for k, v := range req.Meta {
+		var err error
+		if w.Meta[k], err = encodeValue(v); err != nil {
+			return nil, fmt.Errorf("encode meta %s: %w", k, err)
+		}
+	}
+	return json.Marshal(w)
+}
+
+// DecodeRequest decodes the request and its handlers from the wire format,
+// the handlers must be registered by RegisterHandler
+func DecodeRequest(data []byte) (*Request, []CtxHandlerFun, error) {
+	w := wireRequest{}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, nil, err
+	}
+	if w.Version != WireVersion {
+		return nil, nil, fmt.Errorf("unsupported wire version %d", w.Version)
+	}
+	var body io.Reader
+	if len(w.Body) > 0 {
+		body = bytes.NewReader(w.Body)
+	}
+	req := NewRequest(w.Method, w.URL, body)
+	if req.Err != nil {
+		return nil, nil, req.Err
+	}
+	if w.Header != nil {
+		req.Header = w.Header
+	}
+	req.Depth = w.Depth
+	req.ProxyURL = w.ProxyURL
+	req.ResponseCharacterEncoding = w.ResponseCharacterEncoding
+	req.MaxRedirects = w.MaxRedirects
+	req.NoRedirect = w.NoRedirect
+	for k, v := range w.Meta {
+		var err error
+		if req.Meta[k], err = decodeValue(v); err != nil {
+			return nil, nil, fmt.Errorf("decode meta %s: %w", k, err)
+		}
+	}
+	var fns []CtxHandlerFun
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	for _, name := range w.Handlers {
+		fn, ok := handlers[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("handler %s isn't registered", name)
+		}
+		fns = append(fns, fn)
+	}
+	return req, fns, nil
+}
+
+// EncodeItem encodes the item to the wire format,the type of item must be registered by RegisterType
+func EncodeItem(i interface{}) ([]byte, error) {
+	v, err := encodeValue(i)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wireItem{Version: WireVersion, Item: v})
+}
+
+// DecodeItem decodes the item from the wire format
+func DecodeItem(data []byte) (interface{}, error) {
+	w := wireItem{}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	if w.Version != WireVersion {
+		return nil, fmt.Errorf("unsupported wire version %d", w.Version)
+	}
+	return decodeValue(w.Item)
+}
@@ -0,0 +1,104 @@
+package goribot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type wireTestItem struct {
+	Title string
+	Tags  []string
+}
+
+func TestWireFormat(t *testing.T) {
+	RegisterType("wireTestItem", wireTestItem{})
+	RegisterHandler("wire-test", func(ctx *Context) {})
+
+	req := PostRawReq("http://example.com/a?b=1", []byte("body")).
+		SetHeader("X-Test", "1").
+		WithMeta("item", wireTestItem{Title: "t", Tags: []string{"a"}}).
+		WithMeta("n", 1).
+		WithMeta("u", uint64(1)).
+		WithMeta("flag", struct{}{})
+	req.Depth = 2
+	req.ProxyURL = "http://proxy"
+	data, err := EncodeRequest(req, "wire-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, fns, err := DecodeRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Method != http.MethodPost || res.URL.String() != "http://example.com/a?b=1" || string(res.GetBody()) != "body" ||
+		res.Header.Get("X-Test") != "1" || res.Depth != 2 || res.ProxyURL != "http://proxy" || len(fns) != 1 {
+		t.Error("wrong request", res)
+	}
+	if !reflect.DeepEqual(res.Meta, req.Meta) {
+		t.Error("wrong meta", res.Meta)
+	}
+
+	if _, err = EncodeRequest(Get("http://example.com").WithMeta("ch", make(chan int))); !errors.Is(err, ErrUnregisteredType) {
+		t.Error("unregistered type should fail", err)
+	}
+	if _, _, err = DecodeRequest([]byte(`{"v":2,"url":"http://example.com"}`)); err == nil {
+		t.Error("unsupported version should fail")
+	}
+	data, _ = EncodeRequest(Get("http://example.com"), "missing")
+	if _, _, err = DecodeRequest(data); err == nil {
+		t.Error("unregistered handler should fail")
+	}
+
+	data, err = EncodeItem(wireTestItem{Title: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := DecodeItem(data); err != nil || !reflect.DeepEqual(i, wireTestItem{Title: "t"}) {
+		t.Error("wrong item", i, err)
+	}
+}
+
+func TestDecodeErr(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	b := NewMemoryBackend()
+	sName := "DecodeErrTest"
+	_ = b.Push(sName+TasksSuffix, []byte("gob data of old version"))
+	m := NewBackendManager(b, sName)
+	m.SendReq(Get(ts.URL))
+
+	got, errs, encodeErrs := 0, 0, 0
+	s := NewSpider()
+	s.Scheduler = NewDistributedScheduler(b, sName, 10, func(ctx *Context) {
+		got += 1
+		ctx.AddItem(make(chan int))
+	})
+	s.OnError(func(ctx *Context, err error) {
+		var e DecodeErr
+		if errors.As(err, &e) && string(e.Data) == "gob data of old version" {
+			errs += 1
+		}
+		if errors.Is(err, ErrUnregisteredType) && ctx != nil && ctx.Req.URL.String() == ts.URL {
+			encodeErrs += 1
+		}
+	})
+	s.Run()
+	if got != 1 || errs != 1 {
+		t.Error("decode error isn't sent to OnError", got, errs)
+	}
+	if encodeErrs != 1 {
+		t.Error("encode error of item isn't sent to OnError", encodeErrs)
+	}
+
+	_ = b.Push(sName+ItemsSuffix, []byte("{}"))
+	var managerErr error
+	m.OnError(func(err error) {
+		managerErr = err
+	})
+	if m.GetItem() != nil || !errors.As(managerErr, &DecodeErr{}) {
+		t.Error("decode error isn't sent to Manager.OnError", managerErr)
+	}
+}
@@ -0,0 +1,289 @@
+package goribot
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// StatsSuffix is the suffix of the stats of a worker,followed by "_" and the worker id
+const StatsSuffix = "_stats"
+
+// CommandsSuffix is the suffix of the command queue of a worker,followed by "_" and the worker id
+const CommandsSuffix = "_commands"
+
+// WorkerHeartbeatInterval is the default interval of the heartbeats of workers.
+// The commands from Manager are received at the heartbeats as well.
+var WorkerHeartbeatInterval = 5 * time.Second
+
+// WorkerCommand is a command sent to workers by Manager
+type WorkerCommand string
+
+const (
+	// CommandPause makes the worker stop getting new tasks,the running tasks go on
+	CommandPause WorkerCommand = "pause"
+	// CommandResume makes the paused worker get new tasks again
+	CommandResume WorkerCommand = "resume"
+	// CommandStop makes the worker stop getting new tasks and finish after the running tasks are done.
+	// The tasks kept locally are dropped,unless the worker is reliable they are re-queued by the reaper.
+	CommandStop WorkerCommand = "stop"
+)
+
+// WorkerStats is the stats of a worker sent with its heartbeats
+type WorkerStats struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host"`
+	Pid       int       `json:"pid"`
+	StartTime time.Time `json:"start_time"`
+	// LastSeen is the time of the last heartbeat
+	LastSeen time.Time `json:"last_seen"`
+	// Interval is the interval of heartbeats
+	Interval time.Duration `json:"interval"`
+	Reliable bool          `json:"reliable"`
+	Paused   bool          `json:"paused"`
+	Stopping bool          `json:"stopping"`
+	// Running is the number of the running tasks
+	Running int `json:"running"`
+	// Queued is the number of the tasks kept locally
+	Queued int `json:"queued"`
+	// Tasks is the number of the tasks done
+	Tasks uint64 `json:"tasks"`
+	// Items is the number of the items sent
+	Items uint64 `json:"items"`
+	// Errors is the number of the errors occurred
+	Errors uint64 `json:"errors"`
+	// Throughput is the number of tasks done per second during the last interval
+	Throughput float64 `json:"throughput"`
+}
+
+// Alive returns whether the worker sent a heartbeat during the last three intervals
+func (s WorkerStats) Alive() bool {
+	return time.Since(s.LastSeen) < 3*s.Interval
+}
+
+// ClusterStats is the stats of all workers and queues of a distributed spider
+type ClusterStats struct {
+	Workers []WorkerStats
+	// Tasks is the number of the seed tasks waiting in the queue
+	Tasks int64
+	// Items is the number of the items waiting for Manager
+	Items int64
+	// Throughput is the sum of the throughput of the alive workers
+	Throughput float64
+	// Errors is the sum of the errors of all workers
+	Errors uint64
+}
+
+// Workers returns the stats of the registered workers sorted by id,
+// including the dead ones which aren't removed by the reaper yet, see WorkerStats.Alive
+func (s *Manager) Workers() ([]WorkerStats, error) {
+	members, err := s.backend.Members(s.sName + WorkersSuffix)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]WorkerStats, 0, len(members))
+	for id, t := range members {
+		w := WorkerStats{ID: id}
+		data, err := s.backend.GetValue(s.sName + StatsSuffix + "_" + id)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			if err = json.Unmarshal(data, &w); err != nil {
+				s.handleOnError(DecodeErr{err, data})
+			}
+		}
+		w.LastSeen = t
+		res = append(res, w)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// Cluster returns the stats of workers and the depth of queues
+func (s *Manager) Cluster() (*ClusterStats, error) {
+	var err error
+	res := &ClusterStats{}
+	if res.Workers, err = s.Workers(); err != nil {
+		return nil, err
+	}
+	if res.Tasks, err = s.backend.Len(s.sName + TasksSuffix); err != nil {
+		return nil, err
+	}
+	if res.Items, err = s.backend.Len(s.sName + ItemsSuffix); err != nil {
+		return nil, err
+	}
+	for _, w := range res.Workers {
+		if w.Alive() {
+			res.Throughput += w.Throughput
+		}
+		res.Errors += w.Errors
+	}
+	return res, nil
+}
+
+// SendCommand sends the command to the worker,it's received at the next heartbeat of worker
+func (s *Manager) SendCommand(workerID string, cmd WorkerCommand) error {
+	return s.backend.Push(s.sName+CommandsSuffix+"_"+workerID, []byte(cmd))
+}
+
+// Broadcast sends the command to all alive workers
+func (s *Manager) Broadcast(cmd WorkerCommand) error {
+	workers, err := s.Workers()
+	if err != nil {
+		return err
+	}
+	for _, w := range workers {
+		if !w.Alive() {
+			continue
+		}
+		if err = s.SendCommand(w.ID, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Paused returns whether the worker is paused by Manager
+func (s *DistributedScheduler) Paused() bool {
+	return atomic.LoadInt32(&s.paused) == 1 || atomic.LoadInt32(&s.stopped) == 1
+}
+
+// Stats returns the stats of the worker
+func (s *DistributedScheduler) Stats() WorkerStats {
+	host, _ := os.Hostname()
+	res := WorkerStats{
+		ID:        s.workerID,
+		Host:      host,
+		Pid:       os.Getpid(),
+		StartTime: s.startTime,
+		Interval:  s.interval,
+		Reliable:  s.reliable,
+		Paused:    atomic.LoadInt32(&s.paused) == 1,
+		Stopping:  atomic.LoadInt32(&s.stopped) == 1,
+		Queued:    s.base.Len(),
+		Tasks:     atomic.LoadUint64(&s.tasks),
+		Items:     atomic.LoadUint64(&s.items),
+		Errors:    atomic.LoadUint64(&s.errors),
+	}
+	if s.spider != nil {
+		res.Running = s.spider.taskPool.Running()
+	}
+	return res
+}
+
+// heartbeat records the worker is alive with its stats
+func (s *DistributedScheduler) heartbeat() error {
+	now := time.Now()
+	w := s.Stats()
+	if !s.lastBeat.IsZero() {
+		if d := now.Sub(s.lastBeat).Seconds(); d > 0 {
+			w.Throughput = float64(w.Tasks-s.lastTasks) / d
+		}
+	}
+	s.lastBeat, s.lastTasks = now, w.Tasks
+	w.LastSeen = now
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	if err = s.queue.SetValue(s.sName+StatsSuffix+"_"+s.workerID, data); err != nil {
+		return err
+	}
+	return s.queue.Heartbeat(s.sName+WorkersSuffix, s.workerID, now)
+}
+
+// receiveCommands handles the commands sent by Manager
+func (s *DistributedScheduler) receiveCommands() error {
+	for {
+		res, err := s.queue.Pop(s.sName + CommandsSuffix + "_" + s.workerID)
+		if err != nil || res == nil {
+			return err
+		}
+		switch cmd := WorkerCommand(res); cmd {
+		case CommandPause:
+			atomic.StoreInt32(&s.paused, 1)
+		case CommandResume:
+			atomic.StoreInt32(&s.paused, 0)
+		case CommandStop:
+			atomic.StoreInt32(&s.stopped, 1)
+			if s.spider != nil {
+				s.spider.stopRunning()
+			}
+		default:
+			Log.Warning("unknown worker command", cmd)
+		}
+		Log.Info("worker", s.workerID, "received command", string(res))
+	}
+}
+
+// register registers the worker to backend when the spider starts, and sends heartbeats until it finishes
+func (s *DistributedScheduler) register(sp *Spider) {
+	s.registerOnce.Do(func() {
+		s.spider = sp
+		stop := make(chan struct{})
+		sp.OnError(func(ctx *Context, err error) {
+			atomic.AddUint64(&s.errors, 1)
+		})
+		sp.OnStart(func(sp *Spider) {
+			s.startTime = time.Now()
+			if err := s.heartbeat(); err != nil {
+				Log.Error("send heartbeat error", err)
+			}
+			go func() {
+				t := time.NewTicker(s.interval)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+					case <-stop:
+						return
+					}
+					if err := s.receiveCommands(); err != nil {
+						Log.Error("receive commands error", err)
+					}
+					if err := s.heartbeat(); err != nil {
+						Log.Error("send heartbeat error", err)
+					}
+				}
+			}()
+		})
+		sp.OnFinish(func(sp *Spider) {
+			close(stop)
+			s.lock.Lock()
+			n := len(s.processing)
+			s.lock.Unlock()
+			if n > 0 {
+				// the tasks left in processing list are re-queued by the reaper
+				return
+			}
+			s.unregister()
+		})
+	})
+}
+
+// unregister removes the worker and its stats and commands from backend
+func (s *DistributedScheduler) unregister() {
+	for _, err := range []error{
+		s.queue.RemoveMember(s.sName+WorkersSuffix, s.workerID),
+		s.queue.SetValue(s.sName+StatsSuffix+"_"+s.workerID, nil),
+		clearQueue(s.queue, s.sName+CommandsSuffix+"_"+s.workerID),
+	} {
+		if err != nil {
+			Log.Error("unregister worker error", err)
+		}
+	}
+}
+
+func clearQueue(b QueueBackend, queue string) error {
+	for {
+		res, err := b.Pop(queue)
+		if err != nil || res == nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package goribot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWorkerRegistry(t *testing.T) {
+	defer func(i time.Duration) { WorkerHeartbeatInterval = i }(WorkerHeartbeatInterval)
+	WorkerHeartbeatInterval = 50 * time.Millisecond
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	b := NewMemoryBackend()
+	sName := "WorkerTest"
+	m := NewBackendManager(b, sName)
+	m.SendReq(Get(ts.URL + "/a"))
+	m.SendReq(Get("http://127.0.0.1:0/"))
+
+	s := NewSpider(Distributed(b, sName, false, func(ctx *Context) {
+		ctx.AddItem(ctx.Req.URL.Path)
+	}))
+	done := make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+
+	waitWorker := func(fn func(w WorkerStats) bool) WorkerStats {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if ws, err := m.Workers(); err == nil && len(ws) == 1 && fn(ws[0]) {
+				return ws[0]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("wrong worker stats")
+		return WorkerStats{}
+	}
+
+	w := waitWorker(func(w WorkerStats) bool { return w.Tasks == 2 })
+	if w.ID != s.Scheduler.(*DistributedScheduler).WorkerID() || !w.Alive() || w.Items != 1 || w.Errors != 1 {
+		t.Error("wrong worker stats", w)
+	}
+	if c, err := m.Cluster(); err != nil || c.Tasks != 0 || c.Items != 1 || c.Errors != 1 || len(c.Workers) != 1 {
+		t.Error("wrong cluster stats", c, err)
+	}
+
+	if err := m.Broadcast(CommandPause); err != nil {
+		t.Fatal(err)
+	}
+	waitWorker(func(w WorkerStats) bool { return w.Paused })
+	m.SendReq(Get(ts.URL + "/b"))
+	time.Sleep(3 * WorkerHeartbeatInterval)
+	if c, _ := m.Cluster(); c.Tasks != 1 {
+		t.Error("paused worker got task", c.Tasks)
+	}
+
+	if err := m.Broadcast(CommandResume); err != nil {
+		t.Fatal(err)
+	}
+	waitWorker(func(w WorkerStats) bool { return !w.Paused && w.Tasks == 3 })
+
+	if err := m.Broadcast(CommandStop); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("worker isn't stopped")
+	}
+	if ws, err := m.Workers(); len(ws) != 0 || err != nil {
+		t.Error("worker isn't unregistered", ws, err)
+	}
+}